@@ -0,0 +1,45 @@
+package c14n
+
+// Algorithm identifies a canonicalization algorithm.
+type Algorithm int
+
+const (
+	// ExclusiveC14N is Exclusive XML Canonicalization Version 1.0. Namespace
+	// declarations are only rendered on the elements that visibly use them.
+	//
+	// https://www.w3.org/TR/xml-exc-c14n/
+	ExclusiveC14N Algorithm = iota
+
+	// C14N10 is Canonical XML Version 1.0. Every namespace declaration in scope
+	// is rendered on the outermost element it applies to.
+	//
+	// https://www.w3.org/TR/2001/REC-xml-c14n-20010315
+	C14N10
+
+	// C14N11 is Canonical XML Version 1.1. It renders whole documents exactly as
+	// C14N10 does, and differs only in how document subsets inherit attributes
+	// in the xml namespace.
+	//
+	// Its support is partial. Subsets selected with Select inherit xml:lang and
+	// xml:space, but xml:base is neither inherited nor fixed up as the
+	// specification requires, and so the output for subsets whose ancestors
+	// set xml:base differs from that of complete implementations.
+	//
+	// https://www.w3.org/TR/xml-c14n11/
+	C14N11
+)
+
+// String returns the short name commonly used for the algorithm: "exc-c14n",
+// "c14n", or "c14n11".
+func (a Algorithm) String() string {
+	switch a {
+	case ExclusiveC14N:
+		return "exc-c14n"
+	case C14N10:
+		return "c14n"
+	case C14N11:
+		return "c14n11"
+	default:
+		return "unknown"
+	}
+}
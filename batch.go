@@ -0,0 +1,131 @@
+package c14n
+
+import (
+	"context"
+	"hash"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Batch canonicalizes many documents in parallel, using a bounded pool of
+// worker goroutines, each with its own Canonicalizer.
+//
+// The zero value is ready to use, and produces canonical bytes using
+// runtime.GOMAXPROCS(0) workers.
+type Batch struct {
+	// Workers is the maximum number of documents to canonicalize at once. If
+	// Workers is zero or negative, runtime.GOMAXPROCS(0) is used.
+	Workers int
+
+	// Hash, if non-nil, is used to digest the canonical form of each document.
+	// Each Result then holds the digest, rather than the canonical form itself.
+	// This avoids holding every canonical form in memory at once.
+	Hash func() hash.Hash
+
+	// Canonicalizer, if non-nil, holds the options to canonicalize each
+	// document with, such as its Algorithm or WithComments. Each worker uses a
+	// copy of its options, and never the Canonicalizer itself, which is left
+	// untouched and may be shared. Its Select and EntityResolver, if any, are
+	// called from several goroutines at once. If nil, the defaults of the zero
+	// Canonicalizer are used.
+	Canonicalizer *Canonicalizer
+
+	// Limits bounds the resources canonicalizing each document may consume.
+	// If it is non-zero, it takes the place of the Limits of Canonicalizer.
+	// The zero value imposes no limits of its own.
+	Limits Limits
+}
+
+// Result is the outcome of canonicalizing a single document in a Batch.
+type Result struct {
+	// Canonical is the canonical form of the document. It is nil if the Batch
+	// has a Hash, or if Err is non-nil.
+	Canonical []byte
+
+	// Digest is the digest of the canonical form of the document. It is nil if
+	// the Batch has no Hash, or if Err is non-nil.
+	Digest []byte
+
+	// Err is the error encountered canonicalizing the document, if any.
+	Err error
+}
+
+// Canonicalize canonicalizes each of inputs, and returns their results in the
+// same order as inputs. Failing to canonicalize one input does not affect the
+// others; each error is reported in the corresponding Result.
+//
+// If ctx is cancelled, canonicalization of any in-progress inputs is
+// abandoned, inputs that have not yet been started are not read, and the
+// Results of both hold ctx.Err().
+func (b Batch) Canonicalize(ctx context.Context, inputs []RawTokenReader) []Result {
+	workers := b.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+
+	results := make([]Result, len(inputs))
+	next := int64(-1) // the index of the last input claimed by a worker
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c := b.newCanonicalizer()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(inputs) {
+					return
+				}
+
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+
+				results[i] = b.canonicalize(ctx, c, inputs[i])
+			}
+		}()
+	}
+
+	wg.Wait()
+	return results
+}
+
+// newCanonicalizer returns a Canonicalizer for one worker, with the options of
+// b.Canonicalizer and b.Limits, but none of the state of b.Canonicalizer.
+func (b Batch) newCanonicalizer() *Canonicalizer {
+	c := new(Canonicalizer)
+	if b.Canonicalizer != nil {
+		*c = *b.Canonicalizer
+		c.e = encoder{}
+	}
+
+	if b.Limits != (Limits{}) {
+		c.Limits = b.Limits
+	}
+
+	return c
+}
+
+// canonicalize produces the Result for a single input.
+func (b Batch) canonicalize(ctx context.Context, c *Canonicalizer, r RawTokenReader) Result {
+	if b.Hash == nil {
+		out, err := c.CanonicalizeContext(ctx, r)
+		return Result{Canonical: out, Err: err}
+	}
+
+	if err := c.run(ctx, r); err != nil {
+		return Result{Err: err}
+	}
+
+	h := b.Hash()
+	h.Write(c.e.buf.Bytes())
+	return Result{Digest: h.Sum(nil)}
+}
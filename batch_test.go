@@ -0,0 +1,115 @@
+package c14n_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleBatch() {
+	inputs := []c14n.RawTokenReader{
+		xml.NewDecoder(strings.NewReader(`<foo z="2" a="1" />`)),
+		xml.NewDecoder(strings.NewReader(`<bar>`)),
+		xml.NewDecoder(strings.NewReader(`<baz><qux /></baz>`)),
+	}
+
+	for _, result := range (c14n.Batch{Workers: 2}).Canonicalize(context.Background(), inputs) {
+		fmt.Println(string(result.Canonical), result.Err)
+	}
+
+	// Output:
+	// <foo a="1" z="2"></foo> <nil>
+	//  unexpected EOF
+	// <baz><qux></qux></baz> <nil>
+}
+
+func TestBatch(t *testing.T) {
+	var docs []string
+	for i := 0; i < 100; i++ {
+		docs = append(docs, fmt.Sprintf(`<doc n="%d" a="%d"><item /></doc>`, i, i))
+	}
+
+	for _, workers := range []int{0, 1, 4, 1000} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			var inputs []c14n.RawTokenReader
+			for _, doc := range docs {
+				inputs = append(inputs, xml.NewDecoder(strings.NewReader(doc)))
+			}
+
+			results := c14n.Batch{Workers: workers}.Canonicalize(context.Background(), inputs)
+			assert.Len(t, results, len(docs))
+
+			for i, result := range results {
+				assert.NoError(t, result.Err)
+				assert.Nil(t, result.Digest)
+				assert.Equal(t, fmt.Sprintf(`<doc a="%d" n="%d"><item></item></doc>`, i, i), string(result.Canonical))
+			}
+		})
+	}
+}
+
+func TestBatch_Hash(t *testing.T) {
+	inputs := []c14n.RawTokenReader{
+		xml.NewDecoder(strings.NewReader(`<foo z="2" a="1" />`)),
+		&errRawTokener{},
+	}
+
+	results := c14n.Batch{Hash: sha256.New}.Canonicalize(context.Background(), inputs)
+
+	digest := sha256.Sum256([]byte(`<foo a="1" z="2"></foo>`))
+	assert.Equal(t, []c14n.Result{
+		c14n.Result{Digest: digest[:]},
+		c14n.Result{Err: errDummy},
+	}, results)
+}
+
+func TestBatch_Canonicalizer(t *testing.T) {
+	template := &c14n.Canonicalizer{Algorithm: c14n.C14N10, WithComments: true}
+
+	var inputs []c14n.RawTokenReader
+	for i := 0; i < 10; i++ {
+		inputs = append(inputs, xml.NewDecoder(strings.NewReader(`<foo xmlns:a="http://a"><!--c--><bar /></foo>`)))
+	}
+
+	results := c14n.Batch{Workers: 4, Canonicalizer: template}.Canonicalize(context.Background(), inputs)
+	for _, result := range results {
+		assert.NoError(t, result.Err)
+		assert.Equal(t, `<foo xmlns:a="http://a"><!--c--><bar></bar></foo>`, string(result.Canonical))
+	}
+
+	// The template is left as it was, and can still be used on its own.
+	out, err := template.Append(nil, xml.NewDecoder(strings.NewReader(`<baz />`)))
+	assert.NoError(t, err)
+	assert.Equal(t, `<baz></baz>`, string(out))
+
+	// Limits on the Batch take the place of those of the template.
+	template.Limits = c14n.Limits{MaxDepth: 1}
+	inputs = []c14n.RawTokenReader{xml.NewDecoder(strings.NewReader(`<foo><bar /></foo>`))}
+	results = c14n.Batch{Canonicalizer: template, Limits: c14n.Limits{MaxDepth: 2}}.Canonicalize(context.Background(), inputs)
+	assert.NoError(t, results[0].Err)
+}
+
+func TestBatch_Empty(t *testing.T) {
+	results := c14n.Batch{}.Canonicalize(context.Background(), nil)
+	assert.Empty(t, results)
+}
+
+func TestBatch_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	inputs := []c14n.RawTokenReader{&errRawTokener{}, &errRawTokener{}}
+	results := c14n.Batch{}.Canonicalize(ctx, inputs)
+
+	// The inputs were never read, or else their errors would be errDummy.
+	assert.Equal(t, []c14n.Result{
+		c14n.Result{Err: context.Canceled},
+		c14n.Result{Err: context.Canceled},
+	}, results)
+}
@@ -0,0 +1,145 @@
+package c14n_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/ucarion/c14n"
+	"github.com/ucarion/c14n/internal/corpus"
+)
+
+// benchmarkCorpus is the set of documents each benchmark is run against.
+var benchmarkCorpus = []struct {
+	name string
+	doc  []byte
+}{
+	{"saml", corpus.SAMLAssertion()},
+	{"flat/100", corpus.Flat(100)},
+	{"flat/10000", corpus.Flat(10000)},
+	{"deep/10", corpus.Deep(10)},
+	{"deep/1000", corpus.Deep(1000)},
+	{"namespaces/10", corpus.Namespaces(10)},
+	{"namespaces/1000", corpus.Namespaces(1000)},
+	{"escaping/100", corpus.Escaping(100)},
+}
+
+// benchmarkDocs runs f as a sub-benchmark for each document in the corpus.
+func benchmarkDocs(b *testing.B, f func(b *testing.B, doc []byte)) {
+	for _, c := range benchmarkCorpus {
+		doc := c.doc
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(doc)))
+			f(b, doc)
+		})
+	}
+}
+
+func BenchmarkCanonicalize(b *testing.B) {
+	benchmarkDocs(b, func(b *testing.B, doc []byte) {
+		for i := 0; i < b.N; i++ {
+			if _, err := c14n.Canonicalize(xml.NewDecoder(bytes.NewReader(doc))); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkCanonicalize_Tokens measures Canonicalize without the cost of
+// xml.Decoder, by replaying pre-decoded tokens.
+func BenchmarkCanonicalize_Tokens(b *testing.B) {
+	benchmarkDocs(b, func(b *testing.B, doc []byte) {
+		tokens := decodeTokens(b, doc)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s := tokens
+			if _, err := c14n.Canonicalize(&s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkCanonicalizer_Append measures the steady-state cost of
+// canonicalizing with a reused Canonicalizer and output buffer.
+func BenchmarkCanonicalizer_Append(b *testing.B) {
+	benchmarkDocs(b, func(b *testing.B, doc []byte) {
+		tokens := decodeTokens(b, doc)
+
+		var c c14n.Canonicalizer
+		var dst []byte
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s := tokens
+
+			var err error
+			if dst, err = c.Append(dst[:0], &s); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCanonicalizeElement(b *testing.B) {
+	benchmarkDocs(b, func(b *testing.B, doc []byte) {
+		el, err := c14n.Parse(xml.NewDecoder(bytes.NewReader(doc)))
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := c14n.CanonicalizeElement(el); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkEqual(b *testing.B) {
+	benchmarkDocs(b, func(b *testing.B, doc []byte) {
+		for i := 0; i < b.N; i++ {
+			equal, err := c14n.Equal(xml.NewDecoder(bytes.NewReader(doc)), xml.NewDecoder(bytes.NewReader(doc)))
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			if !equal {
+				b.Fatal("document not equal to itself")
+			}
+		}
+	})
+}
+
+// BenchmarkDecoder measures the cost of xml.Decoder alone, as a baseline for
+// BenchmarkCanonicalize.
+func BenchmarkDecoder(b *testing.B) {
+	benchmarkDocs(b, func(b *testing.B, doc []byte) {
+		for i := 0; i < b.N; i++ {
+			decodeTokens(b, doc)
+		}
+	})
+}
+
+// decodeTokens returns copies of the raw tokens in doc.
+func decodeTokens(b *testing.B, doc []byte) c14n.TokenSlice {
+	var tokens c14n.TokenSlice
+
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		t, err := decoder.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				return tokens
+			}
+
+			b.Fatal(err)
+		}
+
+		tokens = append(tokens, xml.CopyToken(t))
+	}
+}
@@ -2,15 +2,19 @@
 // abbbreviated "c14n").
 //
 // https://www.w3.org/TR/xml-exc-c14n/
+//
+// A Canonicalizer can also apply Canonical XML 1.0 and 1.1, with or without
+// comments.
 package c14n
 
 import (
 	"bytes"
 	"encoding/xml"
-	"fmt"
+	"errors"
 	"io"
 	"sort"
 
+	"github.com/ucarion/c14n/internal/dtd"
 	"github.com/ucarion/c14n/internal/sortattr"
 	"github.com/ucarion/c14n/internal/stack"
 )
@@ -25,6 +29,15 @@ type RawTokenReader interface {
 	RawToken() (xml.Token, error)
 }
 
+// ErrUnbalanced is returned when an EndElement does not match the most recent
+// unclosed StartElement, or when there is no unclosed StartElement for it to
+// match.
+var ErrUnbalanced = errors.New("c14n: unbalanced start and end elements")
+
+// ErrMultipleRoots is returned when the whole document is rendered, and an
+// element follows the end of the root element.
+var ErrMultipleRoots = errors.New("c14n: more than one root element")
+
 // Canonicalize returns the canonicalized representation of a sequence of raw
 // XML tokens. In particular, it implements Exclusive Canonical XML, the
 // recommended canonicalization scheme for the SAML protocol.
@@ -33,283 +46,786 @@ type RawTokenReader interface {
 // sequence. Any leading character data, comments, or directives will be
 // skipped.
 //
-// The input stream is not checked for correctness. Canonicalize's behavior is
-// undefined if given unbalanced tokens or other incorrect XML input.
+// Character data is rendered the same way however the input wrote it. A
+// RawTokenReader reports CDATA sections, character references and references
+// to predefined entities as the characters they stand for, as xml.Decoder and
+// Tokenizer do, and Canonicalize then escapes &, <, > and carriage returns as
+// the spec requires. In particular, "]]>" in text is written as "]]&gt;".
+//
+// Canonicalize returns ErrUnbalanced if an EndElement in the input does not
+// match the most recent unclosed StartElement, and a *MarkupError or
+// *InvalidCharError for tokens that cannot be rendered as well-formed XML.
+// Beyond that, the input stream is not checked for correctness: prefixes are
+// written out as given, even if they were never declared.
+//
+// Canonicalize reads and buffers as much input as it is given. When
+// canonicalizing untrusted input, consider using CanonicalizeContext instead,
+// which can bound the resources consumed.
+//
+// Canonicalize is safe for concurrent use. It draws on a pool of
+// Canonicalizers, so that buffers are reused between calls.
 func Canonicalize(r RawTokenReader) ([]byte, error) {
-	var knownNames stack.Stack    // a mapping of all declared namespaces in the input
-	var renderedNames stack.Stack // a mapping of all declared namespaces in the output
-	var buf bytes.Buffer          // the output buffer
+	c := getCanonicalizer()
+	defer putCanonicalizer(c)
 
-	for {
-		t, err := r.RawToken()
-		if err != nil {
-			if err == io.EOF {
-				return nil, io.ErrUnexpectedEOF
+	return c.Canonicalize(r)
+}
+
+// encoder holds the state of an in-progress canonicalization. Each call to next
+// consumes one token from r and appends its canonical form, if any, to buf.
+type encoder struct {
+	r             RawTokenReader
+	knownNames    stack.Stack  // a mapping of all declared namespaces in the input
+	renderedNames stack.Stack  // a mapping of all declared namespaces in the output
+	buf           bytes.Buffer // the output buffer
+
+	// Scratch space for startElement, kept here so that it can be reused from
+	// one element to the next.
+	visiblyUsedNames []string
+	namesToRender    []string
+	sortAttr         sortattr.SortAttr
+
+	openNames []xml.Name // the names of the elements not yet closed
+
+	// Options copied from the Canonicalizer driving this encoder. The zero
+	// values give Exclusive XML Canonicalization of the root element, without
+	// comments.
+	algorithm         Algorithm
+	comments          bool
+	inclusivePrefixes []string
+	wholeDocument     bool
+	selectElement     func(xml.StartElement) bool
+	processDTD        bool
+	entityResolver    EntityResolver
+	replaceInvalid    bool
+	nfc               bool
+	lineEndings       bool
+	trim              bool
+	xml11             bool
+
+	version11 bool // whether the input declared itself an XML 1.1 document
+
+	dtd      *dtd.DTD   // the document type declaration, if processed
+	dtdAttrs []xml.Attr // scratch space for applyDTD
+
+	// The entities an xml.Decoder refers to with markers, and the text each
+	// marker begins with; see expandEntities.
+	entities     *dtd.Expander
+	entityMarker string
+	expansion    int // the size of the replacement text expandEntities has produced
+
+	text    []byte // character data held by bufferText
+	nfcText []byte // scratch space for flushText
+
+	preserveSpace []bool // whether xml:space="preserve" applies in each open element
+
+	rendering bool // whether the root element is open
+	afterRoot bool // whether the root element has been closed
+	rootDepth int  // the number of elements open outside the root element
+
+	// The attributes in the xml namespace declared by the open elements outside
+	// the root element, outermost first. Inclusive canonicalization renders
+	// them on the root element.
+	ancestorAttrs []scopedAttr
+
+	limits Limits // the limits to enforce on the input and output
+	tokens int    // the number of tokens read so far
+}
+
+// scopedAttr is an attribute, and the depth of the element it appeared on.
+type scopedAttr struct {
+	depth int
+	attr  xml.Attr
+}
+
+// reset prepares the encoder to render a new sequence of tokens from r,
+// retaining any memory it has already allocated.
+func (e *encoder) reset(r RawTokenReader) {
+	e.r = r
+	e.algorithm = ExclusiveC14N
+	e.comments = false
+	e.inclusivePrefixes = nil
+	e.wholeDocument = false
+	e.selectElement = nil
+	e.processDTD = false
+	e.entityResolver = nil
+	e.replaceInvalid = false
+	e.nfc = false
+	e.lineEndings = false
+	e.trim = false
+	e.xml11 = false
+	e.version11 = false
+	e.dtd = nil
+	e.entities = nil
+	e.entityMarker = ""
+	e.expansion = 0
+	e.text = e.text[:0]
+	e.preserveSpace = e.preserveSpace[:0]
+	e.rendering = false
+	e.afterRoot = false
+	e.rootDepth = 0
+	e.limits = Limits{}
+	e.tokens = 0
+	e.knownNames.Reset()
+	e.renderedNames.Reset()
+	e.openNames = e.openNames[:0]
+	e.buf.Reset()
+
+	// Don't hold on to the strings of a previous input.
+	for i := range e.visiblyUsedNames {
+		e.visiblyUsedNames[i] = ""
+	}
+
+	for i := range e.namesToRender {
+		e.namesToRender[i] = ""
+	}
+
+	for i := range e.sortAttr.Attrs {
+		e.sortAttr.Attrs[i] = xml.Attr{}
+	}
+
+	for i := range e.dtdAttrs {
+		e.dtdAttrs[i] = xml.Attr{}
+	}
+
+	for i := range e.ancestorAttrs {
+		e.ancestorAttrs[i] = scopedAttr{}
+	}
+
+	e.ancestorAttrs = e.ancestorAttrs[:0]
+}
+
+// configure copies the options of c into the encoder.
+func (e *encoder) configure(c *Canonicalizer) {
+	e.algorithm = c.Algorithm
+	e.comments = c.WithComments
+	e.inclusivePrefixes = c.InclusivePrefixes
+	e.wholeDocument = c.WholeDocument
+	e.selectElement = c.Select
+	e.processDTD = c.ProcessDTD
+	e.entityResolver = c.EntityResolver
+	e.replaceInvalid = c.ReplaceInvalidChars
+	e.nfc = c.NormalizeNFC
+	e.lineEndings = c.NormalizeLineEndings
+	e.trim = c.TrimTextNodes
+	e.xml11 = c.XML11
+	e.limits = c.Limits
+}
+
+// next reads and renders the next token from the underlying reader. It returns
+// true once the end of the first root-level element has been rendered, or, if
+// rendering the whole document, once the input is exhausted.
+func (e *encoder) next() (bool, error) {
+	t, err := e.r.RawToken()
+	if err != nil {
+		if err == io.EOF {
+			if e.afterRoot {
+				return true, nil
 			}
 
-			return nil, err
+			return false, io.ErrUnexpectedEOF
 		}
 
-		switch t := t.(type) {
-		case xml.StartElement:
-			names := map[string]string{}              // the names declared by this element
-			visiblyUsedNames := map[string]struct{}{} // the names visibly used by this element
-
-			visiblyUsedNames[t.Name.Space] = struct{}{}
-			for _, attr := range t.Attr {
-				if name, ok := getNamespace(attr); ok {
-					names[name] = attr.Value
-				} else {
-					visiblyUsedNames[attr.Name.Space] = struct{}{}
-				}
-			}
+		return false, err
+	}
 
-			// Note the previous value of the default namespace. This needs to be
-			// special-cased because the c14n spec special-cases the case of xmlns="".
-			previousDefaultNamespace, _ := knownNames.Get("")
-
-			// Push all the names declared by this element onto the input stack. We
-			// will use this to determine what namespaces to put on the output stack.
-			knownNames.Push(names)
-
-			namesToRender := map[string]struct{}{} // namespaces we will want to output
-			for name, uri := range knownNames.GetAll() {
-				shouldRender := false
-
-				// xmlns="" is special-cased.
-				if name == "" && uri == "" {
-					// Per the spec, from the non-normative but clearer "constrained
-					// implementation":
-					//
-					// Render xmlns="" if and only if all of the conditions are met:
-					//
-					// The default namespace is visibly utilized by the immediate parent
-					// element node, or the default prefix token is present in
-					// InclusiveNamespaces PrefixList, and
-					//
-					// the element does not have a namespace node in the node-set
-					// declaring a value for the default namespace, and
-					//
-					// the default namespace prefix is present in the dictionary
-					// ns_rendered.
-					//
-					// ns_rendered corresponds to renderedNames in this code.
-					_, visiblyUsed := visiblyUsedNames[""]
-					declaredValue, declared := names[""]
-					_, rendered := renderedNames.Get("")
-
-					shouldRender = visiblyUsed && (!declared || declaredValue != previousDefaultNamespace) && rendered
-				} else {
-					// Again from the spec:
-					//
-					// Render each namespace node if and only if all of the conditions are
-					// met:
-					//
-					// it is visibly utilized by the immediate parent element or one of
-					// its attributes, or is present in InclusiveNamespaces PrefixList,
-					// and
-					//
-					// its prefix and value do not appear in ns_rendered.
-					_, visiblyUsed := visiblyUsedNames[name]
-					renderedValue, rendered := renderedNames.Get(name)
-
-					shouldRender = visiblyUsed && (!rendered || renderedValue != uri)
-				}
-
-				if shouldRender {
-					namesToRender[name] = struct{}{}
-				}
-			}
+	if err := e.checkInput(t); err != nil {
+		return false, err
+	}
+
+	if e.entities != nil {
+		if t, err = e.expandEntities(t); err != nil {
+			return false, err
+		}
+	}
+
+	if t, ok := t.(xml.ProcInst); ok && t.Target == "xml" {
+		if err := e.checkVersion(t); err != nil {
+			return false, err
+		}
+	}
+
+	if err := e.checkMarkup(t); err != nil {
+		return false, err
+	}
+
+	if t, err = e.checkChars(t); err != nil {
+		return false, err
+	}
+
+	if _, ok := t.(xml.CharData); !ok && e.normalizesText() {
+		e.flushText()
+	}
+
+	done := false
+	switch t := t.(type) {
+	case xml.StartElement:
+		if e.afterRoot {
+			return false, ErrMultipleRoots
+		}
+
+		if e.dtd != nil {
+			t = e.applyDTD(t)
+		}
+
+		if e.normalizesText() {
+			t = e.normalizeAttrs(t)
+		}
+
+		if e.trim {
+			e.pushSpace(t)
+		}
 
-			// attrsToRender is the set of attributes we'll render. The order doesn't
-			// matter yet, we'll sort them later.
-			attrsToRender := []xml.Attr{}
-			for _, attr := range t.Attr {
-				// Render all non-namespace ndoes.
-				if _, ok := getNamespace(attr); !ok {
-					attrsToRender = append(attrsToRender, attr)
-				}
+		if !e.rendering && e.selectElement != nil && !e.selectElement(t) {
+			e.startAncestor(t)
+			break
+		}
+
+		e.startElement(t)
+	case xml.EndElement:
+		if len(e.openNames) == 0 || e.openNames[len(e.openNames)-1] != t.Name {
+			return false, ErrUnbalanced
+		}
+
+		if e.trim {
+			e.popSpace()
+		}
+
+		if !e.rendering {
+			e.endAncestor()
+			break
+		}
+
+		done = e.endElement(t)
+		if done && e.wholeDocument && e.selectElement == nil {
+			// Keep reading, to render whatever follows the root element.
+			done, e.afterRoot = false, true
+		}
+	case xml.CharData:
+		if e.normalizesText() {
+			e.bufferText(t)
+			break
+		}
+
+		e.charData(t)
+	case xml.Comment:
+		e.comment(t)
+	case xml.ProcInst:
+		e.procInst(t)
+	case xml.Directive:
+		if err := e.directive(t); err != nil {
+			return false, err
+		}
+	}
+
+	if err := e.checkOutput(); err != nil {
+		return false, err
+	}
+
+	return done, nil
+}
+
+// startElement renders an element's start tag, and records the namespaces it
+// declares.
+func (e *encoder) startElement(t xml.StartElement) {
+	// Push all the names declared by this element onto the input stack. We
+	// will use this to determine what namespaces to put on the output stack.
+	//
+	// While we're at it, note the names visibly used by this element. Elements
+	// rarely have more than a handful of attributes, so a slice is cheaper
+	// here than a set.
+	visiblyUsedNames := append(e.visiblyUsedNames[:0], t.Name.Space)
+	if !e.rendering {
+		e.rendering = true
+		e.rootDepth = len(e.openNames)
+	}
+
+	e.knownNames.Push()
+	e.openNames = append(e.openNames, t.Name)
+	for _, attr := range t.Attr {
+		if name, ok := getNamespace(attr); ok {
+			e.knownNames.Bind(name, attr.Value)
+		} else if attr.Name.Space != "" && !containsString(visiblyUsedNames, attr.Name.Space) {
+			// Unprefixed attributes are in no namespace, and so don't use the
+			// default namespace.
+			visiblyUsedNames = append(visiblyUsedNames, attr.Name.Space)
+		}
+	}
+
+	// Exclusive canonicalization only ever renders names that are visibly
+	// used, so those are the only names we need to consider. This keeps the
+	// work done per element proportional to the size of the element, rather
+	// than the number of names in scope.
+	//
+	// The exceptions are the names in the InclusiveNamespaces PrefixList, and
+	// inclusive canonicalization, which considers every name in scope.
+	if e.algorithm == ExclusiveC14N {
+		for _, name := range e.inclusivePrefixes {
+			if name == "#default" {
+				name = ""
 			}
 
-			// renderedNameValues contains the names we're going to render, in a
-			// format we can push onto renderedNames.
-			renderedNameValues := map[string]string{}
-			for name := range namesToRender {
-				uri, _ := knownNames.Get(name)
-				renderedNameValues[name] = uri
-
-				if name == "" {
-					attrsToRender = append(attrsToRender, xml.Attr{
-						Name:  xml.Name{Space: "", Local: "xmlns"},
-						Value: uri,
-					})
-				} else {
-					attrsToRender = append(attrsToRender, xml.Attr{
-						Name:  xml.Name{Space: "xmlns", Local: name},
-						Value: uri,
-					})
-				}
+			if !containsString(visiblyUsedNames, name) {
+				visiblyUsedNames = append(visiblyUsedNames, name)
 			}
+		}
+	} else {
+		visiblyUsedNames = visiblyUsedNames[:0]
+		e.knownNames.Each(func(name, uri string) {
+			visiblyUsedNames = append(visiblyUsedNames, name)
+		})
+	}
 
-			renderedNames.Push(renderedNameValues)
+	namesToRender := e.namesToRender[:0] // namespaces we will want to output
+	for _, name := range visiblyUsedNames {
+		// The xml prefix is bound by definition, and declarations of it are
+		// never rendered.
+		uri, known := e.knownNames.Get(name)
+		if !known || name == "xml" {
+			continue
+		}
 
-			// Establish a sorted order of attributes using SortAttr, which implements
-			// the ordering rules of the c14n spec.
-			sortAttr := sortattr.SortAttr{Stack: &knownNames, Attrs: attrsToRender}
-			sort.Sort(sortAttr)
+		shouldRender := false
 
-			// Write out the element. From the spec:
+		// xmlns="" is special-cased.
+		if name == "" && uri == "" {
+			// Per the spec, from the non-normative but clearer "constrained
+			// implementation":
 			//
-			// If the element is in the node-set, then the result is an open angle
-			// bracket (<), the element QName, the result of processing the namespace
-			// axis, the result of processing the attribute axis, a close angle
-			// bracket (>), [...]
+			// Render xmlns="" if and only if all of the conditions are met:
 			//
-			// Where QName is:
+			// The default namespace is visibly utilized by the immediate parent
+			// element node, or the default prefix token is present in
+			// InclusiveNamespaces PrefixList, and
 			//
-			// The QName of a node is either the local name if the namespace prefix
-			// string is empty or the namespace prefix, a colon, then the local name
-			// of the element. The namespace prefix used in the QName MUST be the same
-			// one which appeared in the input document.
+			// the element does not have a namespace node in the node-set
+			// declaring a value for the default namespace, and
 			//
-			// https://www.w3.org/TR/2001/REC-xml-c14n-20010315#ProcessingModel
+			// the default namespace prefix is present in the dictionary
+			// ns_rendered.
 			//
-			// So here we write out '<' unconditionally, and then write out
-			// space:local if there's a space, or just local otherwise.
+			// ns_rendered corresponds to renderedNames in this code.
 			//
-			// We do not here implement the more complex rules for handling the
-			// default namespace.
-			if t.Name.Space == "" {
-				fmt.Fprintf(&buf, "<%s", t.Name.Local)
-			} else {
-				fmt.Fprintf(&buf, "<%s:%s", t.Name.Space, t.Name.Local)
-			}
-
-			for _, attr := range sortAttr.Attrs {
-				// From the spec:
-				//
-				// Attribute Nodes- a space, the node's QName, an equals sign, an open
-				// quotation mark (double quote), the modified string value, and a close
-				// quotation mark (double quote). The string value of the node is
-				// modified by replacing all ampersands (&) with &amp;, all open angle
-				// brackets (<) with &lt;, all quotation mark characters with &quot;,
-				// and the whitespace characters #x9, #xA, and #xD, with character
-				// references. The character references are written in uppercase
-				// hexadecimal with no leading zeroes (for example, #xD is represented
-				// by the character reference &#xD;).
-				//
-				// QName is already described in a comment above.
-				//
-				// https://www.w3.org/TR/2001/REC-xml-c14n-20010315#ProcessingModel
-				//
-				// xml.EscapeText does not implement this, and practice this is a
-				// significant problem because it will escape single-quotes into
-				// "&#x39;". So we implement our own replacement here.
-				if attr.Name.Space == "" {
-					fmt.Fprintf(&buf, " %s=\"", attr.Name.Local)
-				} else {
-					fmt.Fprintf(&buf, " %s:%s=\"", attr.Name.Space, attr.Name.Local)
-				}
-
-				val := []byte(attr.Value)
-				val = bytes.ReplaceAll(val, amp, escAmp)
-				val = bytes.ReplaceAll(val, lt, escLt)
-				val = bytes.ReplaceAll(val, quot, escQuot)
-				val = bytes.ReplaceAll(val, tab, escTab)
-				val = bytes.ReplaceAll(val, nl, escNl)
-				val = bytes.ReplaceAll(val, cr, escCr)
-				buf.Write(val)
-
-				fmt.Fprint(&buf, "\"")
-			}
-
-			// Having processed the attributes, we now close out the tag:
-			fmt.Fprint(&buf, ">")
-		case xml.EndElement:
-			// Continuing the part of the spec abridged in the StartElement-handling
-			// section:
+			// The name is visibly used, or we wouldn't be considering it. And
+			// since the default namespace is currently empty, this element
+			// cannot be declaring a non-empty value for it.
 			//
-			// [...] an open angle bracket, a forward slash (/), the element QName,
-			// and a close angle bracket.
+			// As for the last condition, ns_rendered records the default
+			// namespace value most recently rendered. Rendering xmlns="" is only
+			// meaningful if that value is non-empty; if it's empty, then an
+			// ancestor has already rendered xmlns="".
+			renderedValue, rendered := e.renderedNames.Get("")
+
+			shouldRender = rendered && renderedValue != ""
+		} else {
+			// Again from the spec:
 			//
-			// We implement that here.
-
-			if t.Name.Space == "" {
-				fmt.Fprintf(&buf, "</%s>", t.Name.Local)
-			} else {
-				fmt.Fprintf(&buf, "</%s:%s>", t.Name.Space, t.Name.Local)
-			}
-
-			knownNames.Pop()
-			renderedNames.Pop()
-
-			if knownNames.Len() == 0 {
-				return buf.Bytes(), nil
-			}
-		case xml.CharData:
-			// From the spec:
+			// Render each namespace node if and only if all of the conditions are
+			// met:
+			//
+			// it is visibly utilized by the immediate parent element or one of
+			// its attributes, or is present in InclusiveNamespaces PrefixList,
+			// and
 			//
-			// Text Nodes- the string value, except all ampersands are replaced by
-			// &amp;, all open angle brackets (<) are replaced by &lt;, all closing
-			// angle brackets (>) are replaced by &gt;, and all #xD characters are
-			// replaced by &#xD;.
+			// its prefix and value do not appear in ns_rendered.
 			//
-			// xml.EscapeText does not implement this, and practice this is a
-			// significant problem because it will escape newlines into "&#xA;". So we
-			// implement our own replacement here.
+			// Again, the name is visibly used, or we wouldn't be considering it.
 			//
-			// Also, to clarify: #xD is usually known as "carriage return" (\r).
+			// Inclusive canonicalization renders every namespace node whose
+			// prefix and value don't appear on the nearest rendered ancestor,
+			// which is the same as this second condition.
+			renderedValue, rendered := e.renderedNames.Get(name)
 
-			// Don't start rendering output until we've reached a StartElement.
-			if knownNames == nil {
-				continue
-			}
+			shouldRender = !rendered || renderedValue != uri
+		}
 
-			t = bytes.ReplaceAll(t, amp, escAmp)
-			t = bytes.ReplaceAll(t, lt, escLt)
-			t = bytes.ReplaceAll(t, gt, escGt)
-			t = bytes.ReplaceAll(t, cr, escCr)
+		if shouldRender {
+			namesToRender = append(namesToRender, name)
+		}
+	}
 
-			buf.Write(t)
-		case xml.ProcInst:
-			// From the spec:
-			//
-			// Processing Instruction (PI) Nodes- The opening PI symbol (<?), the PI
-			// target name of the node, a leading space and the string value if it is
-			// not empty, and the closing PI symbol (?>). If the string value is
-			// empty, then the leading space is not added. Also, a trailing #xA is
-			// rendered after the closing PI symbol for PI children of the root node
-			// with a lesser document order than the document element, and a leading
-			// #xA is rendered before the opening PI symbol of PI children of the root
-			// node with a greater document order than the document element.
-			//
-			// However:
-			//
-			// The XML declaration, including version number and character encoding is
-			// omitted from the canonical form. The encoding is not needed since the
-			// canonical form is encoded in UTF-8. The version is not needed since the
-			// absence of a version number unambiguously indicates XML 1.0.
-			//
-			// https://www.w3.org/TR/2001/REC-xml-c14n-20010315#NoXMLDecl
-			//
-			// We implement this omission by simply checking if the target of the
-			// ProcInst is xml.
+	// attrsToRender is the set of attributes we'll render. The order doesn't
+	// matter yet, we'll sort them later.
+	attrsToRender := e.sortAttr.Attrs[:0]
+	for _, attr := range t.Attr {
+		// Render all non-namespace ndoes.
+		if _, ok := getNamespace(attr); !ok {
+			attrsToRender = append(attrsToRender, attr)
+		}
+	}
 
-			// Don't start rendering output until we've reached a StartElement.
-			if knownNames == nil {
+	// Inclusive canonicalization of a document subset has the root element
+	// inherit the attributes in the xml namespace of its ancestors, unless it
+	// has its own.
+	if len(e.openNames) == e.rootDepth+1 && e.algorithm != ExclusiveC14N {
+		for i := len(e.ancestorAttrs) - 1; i >= 0; i-- {
+			attr := e.ancestorAttrs[i].attr
+			if e.algorithm == C14N11 && attr.Name.Local != "lang" && attr.Name.Local != "space" {
 				continue
 			}
 
-			if t.Target != "xml" {
-				fmt.Fprintf(&buf, "<?%s", t.Target)
-				if len(t.Inst) > 0 {
-					buf.WriteByte(' ')
-				}
-				buf.Write(t.Inst)
-				fmt.Fprintf(&buf, "?>")
+			if !containsAttr(attrsToRender, attr.Name) {
+				attrsToRender = append(attrsToRender, attr)
 			}
 		}
 	}
+
+	// Push the names we're going to render onto the output stack.
+	e.renderedNames.Push()
+	for _, name := range namesToRender {
+		uri, _ := e.knownNames.Get(name)
+		e.renderedNames.Bind(name, uri)
+
+		if name == "" {
+			attrsToRender = append(attrsToRender, xml.Attr{
+				Name:  xml.Name{Space: "", Local: "xmlns"},
+				Value: uri,
+			})
+		} else {
+			attrsToRender = append(attrsToRender, xml.Attr{
+				Name:  xml.Name{Space: "xmlns", Local: name},
+				Value: uri,
+			})
+		}
+	}
+
+	// Establish a sorted order of attributes using SortAttr, which implements
+	// the ordering rules of the c14n spec.
+	e.visiblyUsedNames, e.namesToRender = visiblyUsedNames, namesToRender
+	e.sortAttr = sortattr.SortAttr{Stack: &e.knownNames, Attrs: attrsToRender}
+	sort.Sort(&e.sortAttr)
+
+	// Write out the element. From the spec:
+	//
+	// If the element is in the node-set, then the result is an open angle
+	// bracket (<), the element QName, the result of processing the namespace
+	// axis, the result of processing the attribute axis, a close angle
+	// bracket (>), [...]
+	//
+	// Where QName is:
+	//
+	// The QName of a node is either the local name if the namespace prefix
+	// string is empty or the namespace prefix, a colon, then the local name
+	// of the element. The namespace prefix used in the QName MUST be the same
+	// one which appeared in the input document.
+	//
+	// https://www.w3.org/TR/2001/REC-xml-c14n-20010315#ProcessingModel
+	//
+	// So here we write out '<' unconditionally, and then write out
+	// space:local if there's a space, or just local otherwise.
+	//
+	// We do not here implement the more complex rules for handling the
+	// default namespace.
+	e.buf.WriteByte('<')
+	writeName(&e.buf, t.Name)
+
+	for _, attr := range e.sortAttr.Attrs {
+		// From the spec:
+		//
+		// Attribute Nodes- a space, the node's QName, an equals sign, an open
+		// quotation mark (double quote), the modified string value, and a close
+		// quotation mark (double quote). The string value of the node is
+		// modified by replacing all ampersands (&) with &amp;, all open angle
+		// brackets (<) with &lt;, all quotation mark characters with &quot;,
+		// and the whitespace characters #x9, #xA, and #xD, with character
+		// references. The character references are written in uppercase
+		// hexadecimal with no leading zeroes (for example, #xD is represented
+		// by the character reference &#xD;).
+		//
+		// QName is already described in a comment above.
+		//
+		// https://www.w3.org/TR/2001/REC-xml-c14n-20010315#ProcessingModel
+		//
+		// xml.EscapeText does not implement this, and practice this is a
+		// significant problem because it will escape single-quotes into
+		// "&#x39;". So we implement our own replacement here.
+		e.buf.WriteByte(' ')
+		writeName(&e.buf, attr.Name)
+		e.buf.WriteString("=\"")
+		if e.version11 {
+			escapeAttr11(&e.buf, attr.Value)
+		} else {
+			escapeAttr(&e.buf, attr.Value)
+		}
+		e.buf.WriteByte('"')
+	}
+
+	// Having processed the attributes, we now close out the tag:
+	e.buf.WriteByte('>')
+}
+
+// endElement renders an element's end tag. It returns true if the element was
+// the root element.
+func (e *encoder) endElement(t xml.EndElement) bool {
+	// Continuing the part of the spec abridged in the StartElement-handling
+	// section:
+	//
+	// [...] an open angle bracket, a forward slash (/), the element QName,
+	// and a close angle bracket.
+	//
+	// We implement that here.
+
+	e.buf.WriteString("</")
+	writeName(&e.buf, t.Name)
+	e.buf.WriteByte('>')
+
+	e.knownNames.Pop()
+	e.openNames = e.openNames[:len(e.openNames)-1]
+	e.renderedNames.Pop()
+
+	if len(e.openNames) == e.rootDepth {
+		e.rendering = false
+		return true
+	}
+
+	return false
+}
+
+// startAncestor records the namespaces and xml attributes declared by an
+// element that encloses the selected root element, without rendering it.
+func (e *encoder) startAncestor(t xml.StartElement) {
+	e.knownNames.Push()
+	e.renderedNames.Push()
+	e.openNames = append(e.openNames, t.Name)
+
+	for _, attr := range t.Attr {
+		if name, ok := getNamespace(attr); ok {
+			e.knownNames.Bind(name, attr.Value)
+		} else if attr.Name.Space == "xml" {
+			e.ancestorAttrs = append(e.ancestorAttrs, scopedAttr{depth: len(e.openNames), attr: attr})
+		}
+	}
+}
+
+// endAncestor forgets the most recent element recorded by startAncestor.
+func (e *encoder) endAncestor() {
+	e.knownNames.Pop()
+	e.renderedNames.Pop()
+
+	depth := len(e.openNames)
+	e.openNames = e.openNames[:depth-1]
+
+	n := len(e.ancestorAttrs)
+	for n > 0 && e.ancestorAttrs[n-1].depth == depth {
+		n--
+		e.ancestorAttrs[n] = scopedAttr{}
+	}
+
+	e.ancestorAttrs = e.ancestorAttrs[:n]
+}
+
+// charData renders character data.
+func (e *encoder) charData(t xml.CharData) {
+	// From the spec:
+	//
+	// Text Nodes- the string value, except all ampersands are replaced by
+	// &amp;, all open angle brackets (<) are replaced by &lt;, all closing
+	// angle brackets (>) are replaced by &gt;, and all #xD characters are
+	// replaced by &#xD;.
+	//
+	// xml.EscapeText does not implement this, and practice this is a
+	// significant problem because it will escape newlines into "&#xA;". So we
+	// implement our own replacement here.
+	//
+	// Also, to clarify: #xD is usually known as "carriage return" (\r).
+
+	// Only render character data within the root element.
+	if !e.rendering {
+		return
+	}
+
+	if e.version11 {
+		escapeText11(&e.buf, t)
+		return
+	}
+
+	escapeText(&e.buf, t)
+}
+
+// procInst renders a processing instruction.
+func (e *encoder) procInst(t xml.ProcInst) {
+	// From the spec:
+	//
+	// Processing Instruction (PI) Nodes- The opening PI symbol (<?), the PI
+	// target name of the node, a leading space and the string value if it is
+	// not empty, and the closing PI symbol (?>). If the string value is
+	// empty, then the leading space is not added. Also, a trailing #xA is
+	// rendered after the closing PI symbol for PI children of the root node
+	// with a lesser document order than the document element, and a leading
+	// #xA is rendered before the opening PI symbol of PI children of the root
+	// node with a greater document order than the document element.
+	//
+	// However:
+	//
+	// The XML declaration, including version number and character encoding is
+	// omitted from the canonical form. The encoding is not needed since the
+	// canonical form is encoded in UTF-8. The version is not needed since the
+	// absence of a version number unambiguously indicates XML 1.0.
+	//
+	// https://www.w3.org/TR/2001/REC-xml-c14n-20010315#NoXMLDecl
+	//
+	// We implement this omission by simply checking if the target of the
+	// ProcInst is xml.
+
+	if t.Target == "xml" {
+		return
+	}
+
+	if !e.beginOutsideRoot() {
+		return
+	}
+
+	e.buf.WriteString("<?")
+	e.buf.WriteString(t.Target)
+	if len(t.Inst) > 0 {
+		e.buf.WriteByte(' ')
+	}
+	e.buf.Write(t.Inst)
+	e.buf.WriteString("?>")
+
+	e.endOutsideRoot()
+}
+
+// comment renders a comment, if comments are being rendered.
+func (e *encoder) comment(t xml.Comment) {
+	// From the spec:
+	//
+	// Comment Nodes- Nothing if generating canonical XML without comments. For
+	// canonical XML with comments, generate the opening comment symbol (<!--),
+	// the string value of the node, and the closing comment symbol (-->).
+	// Also, a trailing #xA is rendered after the closing comment symbol for
+	// comment children of the root node with a lesser document order than the
+	// document element, and a leading #xA is rendered before the opening
+	// comment symbol of comment children of the root node with a greater
+	// document order than the document element.
+	if !e.comments {
+		return
+	}
+
+	if !e.beginOutsideRoot() {
+		return
+	}
+
+	e.buf.WriteString("<!--")
+	e.buf.Write(t)
+	e.buf.WriteString("-->")
+
+	e.endOutsideRoot()
+}
+
+// beginOutsideRoot reports whether a comment or processing instruction should
+// be rendered. If it is a child of the root node that follows the root
+// element, beginOutsideRoot writes its leading newline.
+func (e *encoder) beginOutsideRoot() bool {
+	// Inside the root element, everything is rendered.
+	if e.rendering {
+		return true
+	}
+
+	// Outside of it, only when rendering the whole document.
+	if !e.wholeDocument || e.selectElement != nil {
+		return false
+	}
+
+	if e.afterRoot {
+		e.buf.WriteByte('\n')
+	}
+
+	return true
+}
+
+// endOutsideRoot writes the trailing newline of a comment or processing
+// instruction that precedes the root element.
+func (e *encoder) endOutsideRoot() {
+	if !e.rendering && !e.afterRoot {
+		e.buf.WriteByte('\n')
+	}
+}
+
+// directive processes a document type declaration, if DTDs are being
+// processed. Directives are never rendered.
+func (e *encoder) directive(t xml.Directive) error {
+	if !e.processDTD || e.rendering || e.afterRoot || !dtd.IsDoctype(t) {
+		return nil
+	}
+
+	d, err := dtd.Parse(t)
+	if err != nil {
+		return err
+	}
+
+	// Entities are only expanded once something refers to them, and so
+	// external entities are only resolved then.
+	entities := d.NewExpander(e.entityLimit(), e.resolveEntity)
+	if err := d.NormalizeDefaults(entities); err != nil {
+		return e.entityError(err)
+	}
+
+	e.dtd = d
+
+	// The decoder is given a marker for each entity, rather than its
+	// replacement text, so that each reference can be expanded, and counted
+	// toward the limit, as it is read.
+	if decoder, ok := e.r.(*xml.Decoder); ok && len(d.Entities) > 0 {
+		marker, err := newEntityMarker()
+		if err != nil {
+			return err
+		}
+
+		merged := make(map[string]string, len(decoder.Entity)+len(d.Entities))
+		for name, value := range decoder.Entity {
+			merged[name] = value
+		}
+
+		for name, entity := range d.Entities {
+			if entity.NData == "" {
+				merged[name] = marker + name + entityMarkerEnd
+			}
+		}
+
+		decoder.Entity = merged
+		e.entities, e.entityMarker = entities, marker
+	}
+
+	return nil
+}
+
+// entityLimit returns the maximum size of the replacement text that expanding
+// entities may produce.
+func (e *encoder) entityLimit() int {
+	if e.limits.MaxEntityExpansion <= 0 {
+		return DefaultMaxEntityExpansion
+	}
+
+	return e.limits.MaxEntityExpansion
+}
+
+// applyDTD returns t with its attributes normalized according to their
+// declared types, and with any declared default attributes it lacks added.
+// This happens before anything else looks at t, since defaulted attributes may
+// declare namespaces.
+func (e *encoder) applyDTD(t xml.StartElement) xml.StartElement {
+	defs := e.dtd.Attlists[t.Name]
+	if len(defs) == 0 {
+		return t
+	}
+
+	attrs := append(e.dtdAttrs[:0], t.Attr...)
+	for i, attr := range attrs {
+		if def := e.dtd.Lookup(t.Name, attr.Name); def != nil && def.Type != "CDATA" {
+			attrs[i].Value = dtd.NormalizeValue(attr.Value)
+		}
+	}
+
+	for _, def := range defs {
+		if def.HasDefault && !containsAttr(attrs, def.Name) {
+			attrs = append(attrs, xml.Attr{Name: def.Name, Value: def.Default})
+		}
+	}
+
+	e.dtdAttrs = attrs
+	t.Attr = attrs
+	return t
 }
 
 // getNamespace gets the namespace declared by this attribute, and whether it's
@@ -326,21 +842,37 @@ func getNamespace(attr xml.Attr) (string, bool) {
 	return "", false
 }
 
-// These are used in handling xml.CharData and xml.StartElement attribute
-// values.
-var (
-	amp     = []byte("&")
-	escAmp  = []byte("&amp;")
-	lt      = []byte("<")
-	escLt   = []byte("&lt;")
-	gt      = []byte(">")
-	escGt   = []byte("&gt;")
-	cr      = []byte("\r")
-	escCr   = []byte("&#xD;")
-	quot    = []byte("\"")
-	escQuot = []byte("&quot;")
-	tab     = []byte("\t")
-	escTab  = []byte("&#x9;")
-	nl      = []byte("\n")
-	escNl   = []byte("&#xA;")
-)
+// containsAttr returns whether attrs contains an attribute named name.
+func containsAttr(attrs []xml.Attr, name xml.Name) bool {
+	for _, attr := range attrs {
+		if attr.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mapAttrValues returns a copy of t in which f has been applied to the value
+// of each attribute from the i-th on. The attributes are copied, rather than
+// modified in place, since they may be shared with the underlying reader.
+func mapAttrValues(t xml.StartElement, i int, f func(string) string) xml.StartElement {
+	attrs := make([]xml.Attr, len(t.Attr))
+	copy(attrs, t.Attr)
+	for j := i; j < len(attrs); j++ {
+		attrs[j].Value = f(attrs[j].Value)
+	}
+
+	return xml.StartElement{Name: t.Name, Attr: attrs}
+}
+
+// containsString returns whether s contains v.
+func containsString(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,11 +2,14 @@ package c14n_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -25,23 +28,59 @@ func ExampleCanonicalize() {
 }
 
 func TestCanonicalize(t *testing.T) {
-	entries, err := ioutil.ReadDir("tests")
-	assert.NoError(t, err)
+	for _, tt := range readTestCases(t) {
+		t.Run(tt.Name, func(t *testing.T) {
+			if tt.Options.Skip != "" {
+				t.Skip(tt.Options.Skip)
+			}
 
-	for _, file := range entries {
-		t.Run(file.Name(), func(t *testing.T) {
-			in, err := ioutil.ReadFile(fmt.Sprintf("tests/%s/in.xml", file.Name()))
-			assert.NoError(t, err)
-
-			out, err := ioutil.ReadFile(fmt.Sprintf("tests/%s/out.xml", file.Name()))
-			assert.NoError(t, err)
+			if tt.Options.TokenizerOnly != "" {
+				t.Skip(tt.Options.TokenizerOnly)
+			}
 
-			decoder := xml.NewDecoder(bytes.NewReader(in))
+			decoder := xml.NewDecoder(bytes.NewReader(tt.In))
 			decoder.CharsetReader = charset.NewReaderLabel
 
-			actual, err := c14n.Canonicalize(decoder)
+			c := tt.Options.canonicalizer(t, tt.Dir)
+			actual, err := c.Canonicalize(decoder)
+			tt.check(t, actual, err)
+		})
+	}
+}
+
+// TestCanonicalize_CharacterData checks that test cases which write the same
+// document with literal characters, character references, and CDATA sections
+// all share one canonical form.
+func TestCanonicalize_CharacterData(t *testing.T) {
+	var outs []string
+	for _, name := range []string{"text_literal", "text_character_references", "text_cdata"} {
+		out, err := ioutil.ReadFile(filepath.Join("tests", name, "out.xml"))
+		assert.NoError(t, err)
+
+		outs = append(outs, string(out))
+	}
+
+	assert.Equal(t, outs[0], outs[1])
+	assert.Equal(t, outs[0], outs[2])
+
+	inputs := map[string]string{
+		`<a>]]&gt;</a>`:                      `<a>]]&gt;</a>`,
+		`<a><![CDATA[]]]]><![CDATA[>]]></a>`: `<a>]]&gt;</a>`,
+		`<a><![CDATA[>]]>&#62;&gt;></a>`:     `<a>&gt;&gt;&gt;&gt;</a>`,
+		`<a>&#xD;<![CDATA[&#xD;]]></a>`:      `<a>&#xD;&amp;#xD;</a>`,
+		`<a><![CDATA[]]><![CDATA[]]></a>`:    `<a></a>`,
+		`<a>&#x1D11E;<![CDATA[𝄞]]>𝄞</a>`:     `<a>𝄞𝄞𝄞</a>`,
+	}
+
+	for in, out := range inputs {
+		t.Run(in, func(t *testing.T) {
+			actual, err := c14n.Canonicalize(xml.NewDecoder(strings.NewReader(in)))
 			assert.NoError(t, err)
-			assert.Equal(t, out, actual)
+			assert.Equal(t, out, string(actual))
+
+			actual, err = c14n.Canonicalize(c14n.NewTokenizer(strings.NewReader(in)))
+			assert.NoError(t, err)
+			assert.Equal(t, out, string(actual))
 		})
 	}
 }
@@ -52,6 +91,23 @@ func TestCanonicalize_NoStartElement(t *testing.T) {
 	assert.Equal(t, io.ErrUnexpectedEOF, err)
 }
 
+func TestCanonicalize_Unbalanced(t *testing.T) {
+	inputs := []string{
+		`</foo>`,
+		`<foo></bar>`,
+		`<foo><bar></foo>`,
+		`<a:foo></b:foo>`,
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			decoder := xml.NewDecoder(strings.NewReader(input))
+			_, err := c14n.Canonicalize(decoder)
+			assert.Equal(t, c14n.ErrUnbalanced, err)
+		})
+	}
+}
+
 func TestCanonicalize_RawTokenError(t *testing.T) {
 	_, err := c14n.Canonicalize(&errRawTokener{})
 	assert.Equal(t, errDummy, err)
@@ -64,3 +120,190 @@ type errRawTokener struct{}
 func (e *errRawTokener) RawToken() (xml.Token, error) {
 	return nil, errDummy
 }
+
+// testCase is a test case from the tests directory. Each test case is a
+// directory holding an input, in.xml, its expected canonical form, out.xml,
+// and optionally the options to canonicalize it with, options.json.
+//
+// Test cases that expect an error have no out.xml.
+type testCase struct {
+	Name    string
+	Dir     string
+	In      []byte
+	Out     []byte
+	Options testOptions
+}
+
+// check asserts that a canonicalization of tt produced the expected output or
+// error.
+func (tt testCase) check(t *testing.T, actual []byte, err error) {
+	if tt.Options.Error != "" {
+		assert.EqualError(t, err, tt.Options.Error)
+		return
+	}
+
+	assert.NoError(t, err)
+	assert.Equal(t, string(tt.Out), string(actual))
+}
+
+// testOptions is the contents of a test case's options.json. Every field is
+// optional; test cases without an options.json use Exclusive XML
+// Canonicalization of the root element, without comments.
+type testOptions struct {
+	// Algorithm is "exc-c14n", "c14n", or "c14n11".
+	Algorithm string `json:"algorithm"`
+
+	// Comments selects the "#WithComments" variant of the algorithm.
+	Comments bool `json:"comments"`
+
+	// Prefixes is the InclusiveNamespaces PrefixList.
+	Prefixes []string `json:"prefixes"`
+
+	// Document renders the comments and processing instructions outside the
+	// root element.
+	Document bool `json:"document"`
+
+	// Select is the qualified name, as written in the input, of the element
+	// whose subtree is to be canonicalized. The first match is used.
+	Select string `json:"select"`
+
+	// DTD processes the document type declaration.
+	DTD bool `json:"dtd"`
+
+	// Resolve resolves external entities to files in the test case's
+	// directory.
+	Resolve bool `json:"resolve"`
+
+	// NFC applies Unicode Normalization Form C.
+	NFC bool `json:"nfc"`
+
+	// LineEndings applies XML 1.0 end-of-line handling.
+	LineEndings bool `json:"line_endings"`
+
+	// Trim trims whitespace from text nodes.
+	Trim bool `json:"trim"`
+
+	// XML11 canonicalizes XML 1.1 documents.
+	XML11 bool `json:"xml11"`
+
+	// Error is the message of the error canonicalization is expected to
+	// return.
+	Error string `json:"error"`
+
+	// Skip, if set, is why the test case can't pass yet.
+	Skip string `json:"skip"`
+
+	// DecoderOnly, if set, is why the test case only applies to xml.Decoder,
+	// and not to c14n.Tokenizer.
+	DecoderOnly string `json:"decoder_only"`
+
+	// TokenizerOnly, if set, is why the test case only applies to
+	// c14n.Tokenizer, and not to xml.Decoder.
+	TokenizerOnly string `json:"tokenizer_only"`
+}
+
+// isDefault returns whether o is equivalent to having no options.json, apart
+// from which readers the test case applies to.
+func (o testOptions) isDefault() bool {
+	return (o.Algorithm == "" || o.Algorithm == "exc-c14n") && !o.Comments &&
+		len(o.Prefixes) == 0 && !o.Document && o.Select == "" && !o.DTD && !o.Resolve &&
+		!o.NFC && !o.LineEndings && !o.Trim && !o.XML11 &&
+		o.Error == "" && o.Skip == ""
+}
+
+// tokenizer returns a Tokenizer configured with o, to read in, the input of the
+// test case in dir.
+func (o testOptions) tokenizer(in []byte, dir string) *c14n.Tokenizer {
+	tokenizer := c14n.NewTokenizer(bytes.NewReader(in))
+	tokenizer.CharsetReader = charset.NewReaderLabel
+	tokenizer.XML11 = o.XML11
+	if o.Resolve {
+		tokenizer.EntityResolver = c14n.FSResolver(os.DirFS(dir))
+	}
+
+	return tokenizer
+}
+
+// canonicalizer returns a Canonicalizer configured with o, to canonicalize the
+// test case in dir.
+func (o testOptions) canonicalizer(t *testing.T, dir string) *c14n.Canonicalizer {
+	c := &c14n.Canonicalizer{
+		WithComments:         o.Comments,
+		InclusivePrefixes:    o.Prefixes,
+		WholeDocument:        o.Document,
+		ProcessDTD:           o.DTD,
+		NormalizeNFC:         o.NFC,
+		NormalizeLineEndings: o.LineEndings,
+		TrimTextNodes:        o.Trim,
+		XML11:                o.XML11,
+	}
+
+	if o.Resolve {
+		c.EntityResolver = c14n.FSResolver(os.DirFS(dir))
+	}
+
+	if o.Select != "" {
+		c.Select = func(start xml.StartElement) bool {
+			return qualifiedName(start.Name) == o.Select
+		}
+	}
+
+	switch o.Algorithm {
+	case "", "exc-c14n":
+		c.Algorithm = c14n.ExclusiveC14N
+	case "c14n":
+		c.Algorithm = c14n.C14N10
+	case "c14n11":
+		c.Algorithm = c14n.C14N11
+	default:
+		t.Fatalf("unknown algorithm: %q", o.Algorithm)
+	}
+
+	return c
+}
+
+// readTestCases reads all of the test cases in the tests directory.
+func readTestCases(t *testing.T) []testCase {
+	entries, err := ioutil.ReadDir("tests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var testCases []testCase
+	for _, entry := range entries {
+		dir := filepath.Join("tests", entry.Name())
+		tt := testCase{Name: entry.Name(), Dir: dir}
+
+		if tt.In, err = ioutil.ReadFile(filepath.Join(dir, "in.xml")); err != nil {
+			t.Fatal(err)
+		}
+
+		options, err := ioutil.ReadFile(filepath.Join(dir, "options.json"))
+		if err == nil {
+			if err := json.Unmarshal(options, &tt.Options); err != nil {
+				t.Fatalf("%s: %v", entry.Name(), err)
+			}
+		} else if !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+
+		if tt.Out, err = ioutil.ReadFile(filepath.Join(dir, "out.xml")); err != nil {
+			if tt.Options.Error == "" || !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+		}
+
+		testCases = append(testCases, tt)
+	}
+
+	return testCases
+}
+
+// qualifiedName returns name as it would be written in XML.
+func qualifiedName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
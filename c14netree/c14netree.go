@@ -0,0 +1,138 @@
+// Package c14netree adapts github.com/beevik/etree element trees for use with
+// package c14n.
+package c14netree
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/beevik/etree"
+	"github.com/ucarion/c14n"
+)
+
+// Canonicalize returns the canonicalized representation of an etree element.
+// It is shorthand for calling c14n.Canonicalize on NewReader(el).
+func Canonicalize(el *etree.Element) ([]byte, error) {
+	return c14n.Canonicalize(NewReader(el))
+}
+
+// NewReader returns a c14n.RawTokenReader that produces the tokens of el and
+// its descendants, using the prefixes held in the etree.
+//
+// el need not be the root of its document. Namespaces declared by el's
+// ancestors are in scope for el, and so are added as declarations on the first
+// StartElement returned, unless el redeclares them. Exclusive canonicalization
+// only renders those declarations that el or its descendants visibly use.
+func NewReader(el *etree.Element) c14n.RawTokenReader {
+	return &reader{root: el}
+}
+
+type reader struct {
+	root   *etree.Element
+	frames []frame // the currently open elements; the root comes first
+	done   bool    // whether the root's EndElement has been returned
+}
+
+// frame is an open element, and the index of the next child of it to return.
+type frame struct {
+	el    *etree.Element
+	child int
+}
+
+func (r *reader) RawToken() (xml.Token, error) {
+	if r.done {
+		return nil, io.EOF
+	}
+
+	if len(r.frames) == 0 {
+		r.frames = append(r.frames, frame{el: r.root})
+		return startElement(r.root, inheritedNamespaces(r.root)), nil
+	}
+
+	top := &r.frames[len(r.frames)-1]
+	for top.child < len(top.el.Child) {
+		child := top.el.Child[top.child]
+		top.child++
+
+		switch child := child.(type) {
+		case *etree.Element:
+			r.frames = append(r.frames, frame{el: child})
+			return startElement(child, nil), nil
+		case *etree.CharData:
+			return xml.CharData(child.Data), nil
+		case *etree.Comment:
+			return xml.Comment(child.Data), nil
+		case *etree.Directive:
+			return xml.Directive(child.Data), nil
+		case *etree.ProcInst:
+			return xml.ProcInst{Target: child.Target, Inst: []byte(child.Inst)}, nil
+		}
+	}
+
+	r.frames = r.frames[:len(r.frames)-1]
+	r.done = len(r.frames) == 0
+	return xml.EndElement{Name: xml.Name{Space: top.el.Space, Local: top.el.Tag}}, nil
+}
+
+// startElement constructs a StartElement for el, with extra namespace
+// declarations appended to its attributes.
+func startElement(el *etree.Element, extra []xml.Attr) xml.StartElement {
+	attrs := make([]xml.Attr, 0, len(el.Attr)+len(extra))
+	for _, attr := range el.Attr {
+		attrs = append(attrs, xml.Attr{
+			Name:  xml.Name{Space: attr.Space, Local: attr.Key},
+			Value: attr.Value,
+		})
+	}
+
+	attrs = append(attrs, extra...)
+	return xml.StartElement{Name: xml.Name{Space: el.Space, Local: el.Tag}, Attr: attrs}
+}
+
+// inheritedNamespaces returns the namespace declarations made by el's
+// ancestors that are still in scope at el, excluding any that el itself
+// redeclares.
+func inheritedNamespaces(el *etree.Element) []xml.Attr {
+	declared := map[string]struct{}{} // prefixes already declared closer to el
+	for _, attr := range el.Attr {
+		if prefix, ok := namespacePrefix(attr); ok {
+			declared[prefix] = struct{}{}
+		}
+	}
+
+	var out []xml.Attr
+	for p := el.Parent(); p != nil; p = p.Parent() {
+		for _, attr := range p.Attr {
+			prefix, ok := namespacePrefix(attr)
+			if !ok {
+				continue
+			}
+
+			if _, ok := declared[prefix]; ok {
+				continue
+			}
+
+			declared[prefix] = struct{}{}
+			out = append(out, xml.Attr{
+				Name:  xml.Name{Space: attr.Space, Local: attr.Key},
+				Value: attr.Value,
+			})
+		}
+	}
+
+	return out
+}
+
+// namespacePrefix gets the prefix declared by this attribute, and whether it's
+// a namespace-declaring attribute.
+func namespacePrefix(attr etree.Attr) (string, bool) {
+	if attr.Space == "" && attr.Key == "xmlns" {
+		return "", true
+	}
+
+	if attr.Space == "xmlns" {
+		return attr.Key, true
+	}
+
+	return "", false
+}
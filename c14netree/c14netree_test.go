@@ -0,0 +1,102 @@
+package c14netree_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n/c14netree"
+	"golang.org/x/net/html/charset"
+)
+
+func ExampleCanonicalize() {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(`<a:foo xmlns:a="http://example.com" z="2" a="1"><a:bar /></a:foo>`)
+	if err != nil {
+		panic(err)
+	}
+
+	out, err := c14netree.Canonicalize(doc.FindElement("//bar"))
+	fmt.Println(string(out), err)
+	// Output:
+	// <a:bar xmlns:a="http://example.com"></a:bar> <nil>
+}
+
+func TestCanonicalize(t *testing.T) {
+	entries, err := ioutil.ReadDir("../tests")
+	assert.NoError(t, err)
+
+	for _, file := range entries {
+		t.Run(file.Name(), func(t *testing.T) {
+			// Canonicalize only supports the default options. Test cases that
+			// only exclude c14n.Tokenizer still apply.
+			if options, err := ioutil.ReadFile(fmt.Sprintf("../tests/%s/options.json", file.Name())); err == nil {
+				var fields map[string]interface{}
+				assert.NoError(t, json.Unmarshal(options, &fields))
+				delete(fields, "decoder_only")
+
+				if len(fields) > 0 {
+					t.Skip("test case uses options")
+				}
+			}
+
+			out, err := ioutil.ReadFile(fmt.Sprintf("../tests/%s/out.xml", file.Name()))
+			assert.NoError(t, err)
+
+			doc := etree.NewDocument()
+			doc.ReadSettings.CharsetReader = charset.NewReaderLabel
+			err = doc.ReadFromFile(fmt.Sprintf("../tests/%s/in.xml", file.Name()))
+			assert.NoError(t, err)
+
+			actual, err := c14netree.Canonicalize(doc.Root())
+			assert.NoError(t, err)
+			assert.Equal(t, string(out), string(actual))
+		})
+	}
+}
+
+func TestCanonicalize_Subtree(t *testing.T) {
+	type testCase struct {
+		In   string
+		Path string
+		Out  string
+	}
+
+	testCases := []testCase{
+		testCase{
+			In:   `<a:foo xmlns:a="http://example.com/a" xmlns:b="http://example.com/b"><a:bar b:baz="qux" /></a:foo>`,
+			Path: "//bar",
+			Out:  `<a:bar xmlns:a="http://example.com/a" xmlns:b="http://example.com/b" b:baz="qux"></a:bar>`,
+		},
+		testCase{
+			In:   `<foo xmlns="http://example.com/1"><bar xmlns="http://example.com/2"><baz /></bar></foo>`,
+			Path: "//baz",
+			Out:  `<baz xmlns="http://example.com/2"></baz>`,
+		},
+		testCase{
+			In:   `<foo xmlns:a="http://example.com/1"><bar xmlns:a="http://example.com/2"><a:baz /></bar></foo>`,
+			Path: "//baz",
+			Out:  `<a:baz xmlns:a="http://example.com/2"></a:baz>`,
+		},
+		testCase{
+			In:   `<foo xmlns:a="http://example.com/a"><bar>x<!-- y -->z</bar></foo>`,
+			Path: "//bar",
+			Out:  `<bar>xz</bar>`,
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			doc := etree.NewDocument()
+			err := doc.ReadFromString(tt.In)
+			assert.NoError(t, err)
+
+			out, err := c14netree.Canonicalize(doc.FindElement(tt.Path))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Out, string(out))
+		})
+	}
+}
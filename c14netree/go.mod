@@ -0,0 +1,23 @@
+module github.com/ucarion/c14n/c14netree
+
+go 1.18
+
+require (
+	github.com/beevik/etree v1.2.0
+	github.com/stretchr/testify v1.5.1
+	github.com/ucarion/c14n v0.0.0
+	golang.org/x/net v0.0.0-20200506145744-7e3656a0809f
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
+
+// c14netree is developed against the c14n in the parent directory. No tagged
+// release of c14n has the API it uses yet, so the v0.0.0 above only resolves
+// through this replace directive, and c14netree cannot be installed on its
+// own. When releasing, tag the root module first, then require that tag above.
+replace github.com/ucarion/c14n => ../
@@ -0,0 +1,262 @@
+package c14n
+
+import (
+	"context"
+	"encoding/xml"
+	"sync"
+)
+
+// Canonicalizer canonicalizes sequences of raw XML tokens, reusing its
+// internal stacks and buffers from one call to the next. A program that
+// canonicalizes many documents can keep a Canonicalizer around to avoid
+// allocating that state anew for each document.
+//
+// The zero value is ready to use. A Canonicalizer is not safe for concurrent
+// use; use one Canonicalizer per goroutine, or use the package-level
+// Canonicalize, which is safe for concurrent use.
+type Canonicalizer struct {
+	// Algorithm is the canonicalization algorithm to apply. The zero value is
+	// ExclusiveC14N.
+	Algorithm Algorithm
+
+	// WithComments renders comments, as the "#WithComments" variant of each
+	// algorithm does. By default, comments are omitted.
+	WithComments bool
+
+	// InclusivePrefixes is the InclusiveNamespaces PrefixList of Exclusive XML
+	// Canonicalization. Namespaces with these prefixes are rendered wherever
+	// they are in scope, as C14N10 would render them, rather than only where
+	// they are visibly used. The token "#default" denotes the default
+	// namespace. It is ignored by the other algorithms.
+	InclusivePrefixes []string
+
+	// WholeDocument renders the comments and processing instructions that
+	// precede and follow the root element, separated from it by newlines, as
+	// when canonicalizing an entire document. The input is read until io.EOF,
+	// and an element after the root element is an error, ErrMultipleRoots.
+	//
+	// By default, only the root element is rendered, and nothing past its end
+	// is read.
+	WholeDocument bool
+
+	// Select, if non-nil, selects the root element to render: the first
+	// element for which Select returns true. It and its descendants are
+	// rendered as a document subset, with the namespace declarations in scope
+	// from its ancestors rendered as the algorithm requires. Nothing past its
+	// end is read. WholeDocument is ignored.
+	//
+	// C14N10 and C14N11 also render the attributes in the xml namespace that
+	// the selected element inherits from its ancestors. C14N11 inherits only
+	// xml:lang and xml:space; it does not perform xml:base fixup.
+	Select func(xml.StartElement) bool
+
+	// ProcessDTD reads the internal subset of the document type declaration,
+	// and applies the declarations that affect the document's content:
+	//
+	// Internal general entities are expanded wherever they are referenced in
+	// text and attribute values.
+	//
+	// Attributes declared with a default value are added to the elements that
+	// lack them, and the values of attributes declared with a type other than
+	// CDATA, such as ID or NMTOKENS, are normalized: leading and trailing
+	// spaces are removed, and runs of spaces are collapsed to one.
+	//
+	// If the input is an *xml.Decoder, its Entity field is replaced with a map
+	// that adds the declared entities to any it already held. The decoder
+	// substitutes a marker for each reference to them, which the Canonicalizer
+	// then expands. Other readers, such as Tokenizer, must expand entities
+	// themselves, with settings of their own. The decoder cannot expand
+	// entities whose replacement text contains markup, and so references to
+	// them remain an error.
+	//
+	// External parsed entities are expanded with the content EntityResolver
+	// provides for them. The expansion is bounded by Limits.MaxEntityExpansion,
+	// which counts every reference in the document.
+	ProcessDTD bool
+
+	// EntityResolver obtains the content of the external entities a document
+	// refers to. Each is resolved once, when it is first referred to; entities
+	// that are declared but never referred to are not resolved at all. If it
+	// is nil, then documents that refer to external parsed entities are
+	// refused with ErrExternalEntity. It is only consulted if ProcessDTD is
+	// set, and is not used by readers that expand entities themselves, such as
+	// Tokenizer, which has an EntityResolver of its own.
+	EntityResolver EntityResolver
+
+	// ReplaceInvalidChars replaces each character in the input that is outside
+	// the Char production of XML 1.0, and each byte of invalid UTF-8, with
+	// U+FFFD. By default, such input is rejected with an *InvalidCharError.
+	//
+	// Names, comments and processing instructions that could not be rendered
+	// as well-formed XML, such as a comment containing "--", are rejected with
+	// a *MarkupError either way.
+	ReplaceInvalidChars bool
+
+	// NormalizeNFC applies Unicode Normalization Form C to character data and
+	// attribute values, so that text which differs only in how its characters
+	// are composed, such as "é" written as one code point or as "e" followed
+	// by a combining acute accent, canonicalizes identically.
+	//
+	// The canonicalization algorithms recommend that documents be normalized
+	// this way, but do not do so themselves. Output produced with NormalizeNFC
+	// set differs from that of other implementations whenever the input is not
+	// already normalized.
+	NormalizeNFC bool
+
+	// NormalizeLineEndings applies the end-of-line handling of XML 1.0 to
+	// character data and attribute values before they are escaped: each CRLF
+	// sequence, and each CR not followed by LF, becomes a single LF.
+	//
+	// xml.Decoder and Tokenizer already do this, and leave a CR in the input
+	// only where it was written as a character reference, which must be
+	// preserved. Set NormalizeLineEndings only for readers that do not, such
+	// as token slices built in code, which would otherwise render each CR as
+	// "&#xD;". A CR the reader got from a character reference is normalized
+	// too, since it cannot be told apart from a literal one.
+	NormalizeLineEndings bool
+
+	// TrimTextNodes removes the leading and trailing whitespace of each text
+	// node, and so drops text nodes made only of whitespace, such as the
+	// indentation between elements. Documents that differ only in how they
+	// are indented then canonicalize identically. Mixed content is trimmed
+	// too: "Some <b>bold</b> text" becomes "Some<b>bold</b>text". Text within
+	// an element with xml:space="preserve", or within its descendants, is left
+	// as-is, unless a descendant restores xml:space="default".
+	//
+	// This is the TrimTextNodes parameter of Canonical XML 2.0. It is not
+	// part of the algorithms this package implements, and output produced
+	// with it set differs from that of other implementations.
+	//
+	// https://www.w3.org/TR/xml-c14n2/#sec-Parameters
+	TrimTextNodes bool
+
+	// XML11 canonicalizes documents that declare themselves XML 1.1. They are
+	// otherwise rejected, with ErrXML11 or, as xml.Decoder and Tokenizer do,
+	// by the reader itself. The canonicalization algorithms are defined only
+	// for XML 1.0, so this package extends them as follows.
+	//
+	// In XML 1.1, text and attribute values may hold most control characters,
+	// written as character references, and NEL and LS characters are line
+	// endings. So, in addition to the characters the algorithms escape, the
+	// restricted characters of XML 1.1, NEL and LS are rendered as character
+	// references, such as "&#x1;". Like the XML declaration of any document,
+	// that of an XML 1.1 document is omitted, and so the output must be read
+	// as XML 1.1 by other means.
+	//
+	// xml.Decoder cannot read XML 1.1 documents; use a Tokenizer with its
+	// XML11 field set, which handles their line endings and character
+	// references. NormalizeLineEndings also applies the end-of-line handling
+	// of XML 1.1 to them.
+	XML11 bool
+
+	// Limits bounds the resources each call may consume. The zero value
+	// imposes no limits.
+	Limits Limits
+
+	e encoder
+}
+
+// Canonicalize returns the canonicalized representation of a sequence of raw
+// XML tokens. It behaves like the package-level Canonicalize.
+//
+// The returned slice is newly allocated, and remains valid after later calls
+// to the Canonicalizer. Use Append to avoid that allocation.
+func (c *Canonicalizer) Canonicalize(r RawTokenReader) ([]byte, error) {
+	return c.CanonicalizeContext(context.Background(), r)
+}
+
+// CanonicalizeContext is like Canonicalize, but gives up if ctx is done,
+// returning ctx.Err().
+func (c *Canonicalizer) CanonicalizeContext(ctx context.Context, r RawTokenReader) ([]byte, error) {
+	if err := c.run(ctx, r); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, c.e.buf.Len())
+	copy(out, c.e.buf.Bytes())
+	return out, nil
+}
+
+// Append appends the canonicalized representation of a sequence of raw XML
+// tokens to dst, and returns the extended slice. If dst has enough capacity,
+// Append does not allocate memory for its output.
+//
+// If an error occurs, Append returns dst unmodified along with the error.
+func (c *Canonicalizer) Append(dst []byte, r RawTokenReader) ([]byte, error) {
+	return c.AppendContext(context.Background(), dst, r)
+}
+
+// AppendContext is like Append, but gives up if ctx is done, returning
+// ctx.Err().
+func (c *Canonicalizer) AppendContext(ctx context.Context, dst []byte, r RawTokenReader) ([]byte, error) {
+	if err := c.run(ctx, r); err != nil {
+		return dst, err
+	}
+
+	return append(dst, c.e.buf.Bytes()...), nil
+}
+
+// Reset discards any state left behind by a previous call, including state
+// from a canonicalization that was cut short by an error, and releases
+// references to previous inputs. The memory the Canonicalizer has allocated is
+// retained for reuse.
+//
+// Canonicalize and Append reset the Canonicalizer before they begin, so it is
+// never necessary to call Reset between calls.
+func (c *Canonicalizer) Reset() {
+	c.e.reset(nil)
+}
+
+// run renders all of r into c's output buffer.
+func (c *Canonicalizer) run(ctx context.Context, r RawTokenReader) error {
+	c.e.reset(r)
+	c.e.configure(c)
+	defer func() { c.e.r = nil }()
+
+	ctxDone := ctx.Done()
+	for {
+		// Done returns nil for contexts that can never be cancelled, in which
+		// case this select is nearly free.
+		select {
+		case <-ctxDone:
+			return ctx.Err()
+		default:
+		}
+
+		done, err := c.e.next()
+		if err != nil {
+			return err
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// maxPooledBufferSize is the largest output buffer a pooled Canonicalizer may
+// retain. Canonicalizers that have grown larger than this are not returned to
+// the pool, so that one unusually large document doesn't pin a large buffer in
+// memory indefinitely.
+const maxPooledBufferSize = 64 << 10
+
+var canonicalizerPool = sync.Pool{
+	New: func() interface{} {
+		return new(Canonicalizer)
+	},
+}
+
+// getCanonicalizer fetches a Canonicalizer from the pool.
+func getCanonicalizer() *Canonicalizer {
+	return canonicalizerPool.Get().(*Canonicalizer)
+}
+
+// putCanonicalizer returns a Canonicalizer to the pool.
+func putCanonicalizer(c *Canonicalizer) {
+	if c.e.buf.Cap() > maxPooledBufferSize {
+		return
+	}
+
+	c.Reset()
+	canonicalizerPool.Put(c)
+}
@@ -0,0 +1,251 @@
+package c14n_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+	"github.com/ucarion/c14n/internal/corpus"
+	"golang.org/x/net/html/charset"
+)
+
+func ExampleCanonicalizer() {
+	var c c14n.Canonicalizer
+	var out []byte
+
+	for _, input := range []string{`<foo z="2" a="1" />`, `<bar><baz /></bar>`} {
+		var err error
+		out, err = c.Append(out[:0], xml.NewDecoder(strings.NewReader(input)))
+		fmt.Println(string(out), err)
+	}
+
+	// Output:
+	// <foo a="1" z="2"></foo> <nil>
+	// <bar><baz></baz></bar> <nil>
+}
+
+func TestCanonicalizer(t *testing.T) {
+	// Use the same Canonicalizer for every test case, to make sure no state
+	// leaks from one call to the next.
+	var c c14n.Canonicalizer
+	for _, tt := range readTestCases(t) {
+		t.Run(tt.Name, func(t *testing.T) {
+			if tt.Options.Skip != "" {
+				t.Skip(tt.Options.Skip)
+			}
+
+			if tt.Options.TokenizerOnly != "" {
+				t.Skip(tt.Options.TokenizerOnly)
+			}
+
+			decoder := xml.NewDecoder(bytes.NewReader(tt.In))
+			decoder.CharsetReader = charset.NewReaderLabel
+
+			options := tt.Options.canonicalizer(t, tt.Dir)
+			c.Algorithm = options.Algorithm
+			c.WithComments = options.WithComments
+			c.InclusivePrefixes = options.InclusivePrefixes
+			c.WholeDocument = options.WholeDocument
+			c.Select = options.Select
+			c.ProcessDTD = options.ProcessDTD
+			c.EntityResolver = options.EntityResolver
+			c.NormalizeNFC = options.NormalizeNFC
+			c.NormalizeLineEndings = options.NormalizeLineEndings
+			c.TrimTextNodes = options.TrimTextNodes
+			c.XML11 = options.XML11
+
+			actual, err := c.Canonicalize(decoder)
+			tt.check(t, actual, err)
+		})
+	}
+}
+
+func TestCanonicalizer_AfterError(t *testing.T) {
+	var c c14n.Canonicalizer
+
+	// Leave the Canonicalizer partway through a document.
+	_, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(`<a:foo xmlns:a="http://example.com"><a:bar>`)))
+	assert.Error(t, err)
+
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(`<a:foo xmlns:a="http://example.com" />`)))
+	assert.NoError(t, err)
+	assert.Equal(t, `<a:foo xmlns:a="http://example.com"></a:foo>`, string(out))
+
+	// Append leaves dst as-is on error.
+	dst := []byte("prefix")
+	dst, err = c.Append(dst, &errRawTokener{})
+	assert.Equal(t, errDummy, err)
+	assert.Equal(t, "prefix", string(dst))
+
+	c.Reset()
+
+	dst, err = c.Append(dst, xml.NewDecoder(strings.NewReader(`<foo />`)))
+	assert.NoError(t, err)
+	assert.Equal(t, "prefix<foo></foo>", string(dst))
+}
+
+func TestCanonicalizer_WholeDocument(t *testing.T) {
+	c := c14n.Canonicalizer{WholeDocument: true, WithComments: true}
+
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader("<?pi?><!--a--><foo/><!--b-->\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, "<?pi?>\n<!--a-->\n<foo></foo>\n<!--b-->", string(out))
+
+	// The input must still contain a complete root element.
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader("<!--a--><foo>")))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// And only one.
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader("<foo/><bar/>")))
+	assert.Equal(t, c14n.ErrMultipleRoots, err)
+}
+
+func TestCanonicalizer_NormalizeNFC(t *testing.T) {
+	// A base character and a combining mark, split across tokens.
+	tokens := func() *c14n.TokenSlice {
+		return &c14n.TokenSlice{
+			xml.StartElement{Name: xml.Name{Local: "foo"}, Attr: []xml.Attr{
+				{Name: xml.Name{Local: "a"}, Value: "A\u030a"},
+			}},
+			xml.CharData("e"),
+			xml.CharData("\u0301"),
+			xml.EndElement{Name: xml.Name{Local: "foo"}},
+		}
+	}
+
+	var c c14n.Canonicalizer
+	out, err := c.Canonicalize(tokens())
+	assert.NoError(t, err)
+	assert.Equal(t, "<foo a=\"A\u030a\">e\u0301</foo>", string(out))
+
+	c.NormalizeNFC = true
+	out, err = c.Canonicalize(tokens())
+	assert.NoError(t, err)
+	assert.Equal(t, "<foo a=\"\u00c5\">\u00e9</foo>", string(out))
+}
+
+func TestCanonicalizer_NormalizeLineEndings(t *testing.T) {
+	// CRLF and lone CR line endings, with a CRLF split across tokens.
+	tokens := func() *c14n.TokenSlice {
+		return &c14n.TokenSlice{
+			xml.StartElement{Name: xml.Name{Local: "foo"}, Attr: []xml.Attr{
+				{Name: xml.Name{Local: "a"}, Value: "1\r\n2\r3"},
+			}},
+			xml.CharData("a\r\nb\r"),
+			xml.CharData("\nc\r\r\n"),
+			xml.EndElement{Name: xml.Name{Local: "foo"}},
+		}
+	}
+
+	var c c14n.Canonicalizer
+	out, err := c.Canonicalize(tokens())
+	assert.NoError(t, err)
+	assert.Equal(t, "<foo a=\"1&#xD;&#xA;2&#xD;3\">a&#xD;\nb&#xD;\nc&#xD;&#xD;\n</foo>", string(out))
+
+	c.NormalizeLineEndings = true
+	out, err = c.Canonicalize(tokens())
+	assert.NoError(t, err)
+	assert.Equal(t, "<foo a=\"1&#xA;2&#xA;3\">a\nb\nc\n\n</foo>", string(out))
+}
+
+func TestCanonicalizer_TrimTextNodes(t *testing.T) {
+	pretty := `<foo>
+  <bar a="1">
+    text
+    <!-- comment -->
+  </bar>
+  <baz xml:space="preserve"> <qux xml:space="default"> x </qux> </baz>
+</foo>
+`
+
+	compact := `<foo><bar a="1">text<!-- comment --></bar>` +
+		`<baz xml:space="preserve"> <qux xml:space="default">x</qux> </baz></foo>`
+
+	want := `<foo><bar a="1">text</bar>` +
+		`<baz xml:space="preserve"> <qux xml:space="default">x</qux> </baz></foo>`
+
+	c := c14n.Canonicalizer{TrimTextNodes: true}
+	for _, input := range []string{pretty, compact} {
+		out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(out))
+	}
+}
+
+func TestCanonicalizer_Canonicalize_NotAliased(t *testing.T) {
+	var c c14n.Canonicalizer
+
+	a, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(`<foo />`)))
+	assert.NoError(t, err)
+
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader(`<bar />`)))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "<foo></foo>", string(a))
+}
+
+func TestCanonicalizer_Allocs(t *testing.T) {
+	tokens := decodeCorpusTokens(t, corpus.SAMLAssertion())
+
+	var c c14n.Canonicalizer
+	var dst []byte
+	var s c14n.TokenSlice
+
+	canonicalize := func() {
+		s = tokens
+		out, err := c.Append(dst[:0], &s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dst = out
+	}
+
+	// Warm up the Canonicalizer's buffers, and then make sure that subsequent
+	// calls don't allocate.
+	canonicalize()
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, canonicalize))
+}
+
+func TestCanonicalize_Concurrent(t *testing.T) {
+	doc := corpus.SAMLAssertion()
+	want, err := c14n.Canonicalize(xml.NewDecoder(bytes.NewReader(doc)))
+	assert.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				out, err := c14n.Canonicalize(xml.NewDecoder(bytes.NewReader(doc)))
+				assert.NoError(t, err)
+				assert.Equal(t, want, out)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// decodeCorpusTokens returns copies of the raw tokens in doc.
+func decodeCorpusTokens(t *testing.T, doc []byte) c14n.TokenSlice {
+	var tokens c14n.TokenSlice
+
+	decoder := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		tok, err := decoder.RawToken()
+		if err != nil {
+			return tokens
+		}
+
+		tokens = append(tokens, xml.CopyToken(tok))
+	}
+}
@@ -0,0 +1,247 @@
+package c14n
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/ucarion/c14n/internal/xmlchar"
+)
+
+// InvalidCharError is returned when the input holds a character outside the
+// Char production of XML 1.0, such as most ASCII control characters, or a
+// sequence of bytes that is not valid UTF-8. Such input cannot be rendered as
+// well-formed XML. In an XML 1.1 document, the Char production of XML 1.1
+// applies instead, except to comments and processing instructions, which may
+// not hold its restricted characters.
+//
+// xml.Decoder and Tokenizer reject such input themselves; this error arises
+// from other readers, and from trees built in code. A Canonicalizer with
+// ReplaceInvalidChars set replaces such characters instead.
+type InvalidCharError struct {
+	// Token is the position of the token holding the character in the input,
+	// counting from one.
+	Token int
+
+	// Attr is the name of the attribute whose value holds the character, or
+	// the zero Name if the character is in the token's text: its character
+	// data, comment, or processing instruction.
+	Attr xml.Name
+
+	// Offset is the byte offset of the character within the text or attribute
+	// value.
+	Offset int
+
+	// Rune is the invalid character, or utf8.RuneError for invalid UTF-8.
+	Rune rune
+}
+
+func (e *InvalidCharError) Error() string {
+	what := fmt.Sprintf("character %U", e.Rune)
+	if e.Rune == utf8.RuneError {
+		what = "UTF-8"
+	}
+
+	where := fmt.Sprintf("token %d", e.Token)
+	if e.Attr != (xml.Name{}) {
+		where = fmt.Sprintf("attribute %q of token %d", qualifiedName(e.Attr), e.Token)
+	}
+
+	return fmt.Sprintf("c14n: invalid %s at offset %d of %s", what, e.Offset, where)
+}
+
+// MarkupError is returned when the input holds a token that cannot be rendered
+// as well-formed XML, whatever characters it holds: an element or attribute
+// whose name does not match the Name production of XML, a comment that
+// contains "--" or ends in "-", or a processing instruction whose target is not
+// a Name or whose instruction contains "?>".
+//
+// xml.Decoder and Tokenizer never return such tokens; this error arises from
+// other readers, and from trees built in code. ReplaceInvalidChars does not
+// apply to it.
+type MarkupError struct {
+	// Token is the position of the malformed token in the input, counting
+	// from one.
+	Token int
+
+	// Reason describes what is wrong with the token.
+	Reason string
+}
+
+func (e *MarkupError) Error() string {
+	return fmt.Sprintf("c14n: malformed token %d: %s", e.Token, e.Reason)
+}
+
+// checkMarkup checks the names of t, and the delimiters its text may not hold,
+// returning a *MarkupError for the first problem it finds.
+func (e *encoder) checkMarkup(t xml.Token) error {
+	var reason string
+	switch t := t.(type) {
+	case xml.StartElement:
+		if !isQName(t.Name) {
+			reason = fmt.Sprintf("invalid element name %q", qualifiedName(t.Name))
+			break
+		}
+
+		for _, attr := range t.Attr {
+			if !isQName(attr.Name) {
+				reason = fmt.Sprintf("invalid attribute name %q", qualifiedName(attr.Name))
+				break
+			}
+		}
+	case xml.Comment:
+		if bytes.Contains(t, []byte("--")) {
+			reason = `comment contains "--"`
+		} else if bytes.HasSuffix(t, []byte("-")) {
+			reason = `comment ends in "-"`
+		}
+	case xml.ProcInst:
+		if !xmlchar.IsName(t.Target) {
+			reason = fmt.Sprintf("invalid processing instruction target %q", t.Target)
+		} else if bytes.Contains(t.Inst, []byte("?>")) {
+			reason = `processing instruction contains "?>"`
+		}
+	}
+
+	if reason != "" {
+		return &MarkupError{Token: e.tokens, Reason: reason}
+	}
+
+	return nil
+}
+
+// isQName returns whether n, as a raw token holds it, renders as a Name: its
+// local part is a Name, and so is its prefix, if it has one.
+func isQName(n xml.Name) bool {
+	return xmlchar.IsName(n.Local) && (n.Space == "" || xmlchar.IsName(n.Space))
+}
+
+// qualifiedName returns n as it would be rendered.
+func qualifiedName(n xml.Name) string {
+	var b bytes.Buffer
+	writeName(&b, n)
+	return b.String()
+}
+
+// checkChars checks the text and attribute values of t for characters outside
+// the XML Char production and for invalid UTF-8. It returns an
+// *InvalidCharError for the first it finds, or, if replacing them, a copy of t
+// in which each is replaced by U+FFFD.
+func (e *encoder) checkChars(t xml.Token) (xml.Token, error) {
+	// Text and attribute values can hold characters that comments and
+	// processing instructions cannot, if they are rendered as references.
+	text, literal := xmlchar.IsChar, xmlchar.IsChar
+	if e.version11 {
+		text, literal = xmlchar.IsChar11, isLiteralChar11
+	}
+
+	switch t := t.(type) {
+	case xml.StartElement:
+		for i, attr := range t.Attr {
+			offset, r := invalidCharString(attr.Value, text)
+			if offset < 0 {
+				continue
+			}
+
+			if !e.replaceInvalid {
+				return nil, &InvalidCharError{Token: e.tokens, Attr: attr.Name, Offset: offset, Rune: r}
+			}
+
+			return mapAttrValues(t, i, func(s string) string {
+				return replaceInvalidString(s, text)
+			}), nil
+		}
+	case xml.CharData:
+		if offset, r := invalidChar(t, text); offset >= 0 {
+			if !e.replaceInvalid {
+				return nil, &InvalidCharError{Token: e.tokens, Offset: offset, Rune: r}
+			}
+
+			return xml.CharData(replaceInvalid(t, text)), nil
+		}
+	case xml.Comment:
+		if offset, r := invalidChar(t, literal); offset >= 0 {
+			if !e.replaceInvalid {
+				return nil, &InvalidCharError{Token: e.tokens, Offset: offset, Rune: r}
+			}
+
+			return xml.Comment(replaceInvalid(t, literal)), nil
+		}
+	case xml.ProcInst:
+		if offset, r := invalidChar(t.Inst, literal); offset >= 0 {
+			if !e.replaceInvalid {
+				return nil, &InvalidCharError{Token: e.tokens, Offset: offset, Rune: r}
+			}
+
+			return xml.ProcInst{Target: t.Target, Inst: replaceInvalid(t.Inst, literal)}, nil
+		}
+	}
+
+	return t, nil
+}
+
+// invalidChar returns the offset of the first character of s for which valid
+// returns false, and that character, or utf8.RuneError if the first such
+// problem is invalid UTF-8. If s has no such problem, the offset is -1.
+//
+// Most text is printable ASCII, which every version of XML allows, and which
+// is checked a byte at a time.
+func invalidChar(s []byte, valid func(rune) bool) (int, rune) {
+	for i := 0; i < len(s); {
+		if c := s[i]; c >= 0x20 && c < 0x7F || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 || !valid(r) {
+			return i, r
+		}
+
+		i += size
+	}
+
+	return -1, 0
+}
+
+// invalidCharString is like invalidChar, but for strings.
+func invalidCharString(s string, valid func(rune) bool) (int, rune) {
+	for i := 0; i < len(s); {
+		if c := s[i]; c >= 0x20 && c < 0x7F || c == '\t' || c == '\n' || c == '\r' {
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 || !valid(r) {
+			return i, r
+		}
+
+		i += size
+	}
+
+	return -1, 0
+}
+
+// replaceInvalid returns a copy of s in which each character for which valid
+// returns false, and each byte of invalid UTF-8, is replaced by U+FFFD.
+func replaceInvalid(s []byte, valid func(rune) bool) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		if r == utf8.RuneError && size == 1 || !valid(r) {
+			r = utf8.RuneError
+		}
+
+		out = utf8.AppendRune(out, r)
+		i += size
+	}
+
+	return out
+}
+
+// replaceInvalidString is like replaceInvalid, but for strings.
+func replaceInvalidString(s string, valid func(rune) bool) string {
+	return string(replaceInvalid([]byte(s), valid))
+}
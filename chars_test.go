@@ -0,0 +1,210 @@
+package c14n_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleCanonicalizer_ReplaceInvalidChars() {
+	tokens := c14n.TokenSlice{
+		xml.StartElement{Name: xml.Name{Local: "foo"}},
+		xml.CharData("bell\x07"),
+		xml.EndElement{Name: xml.Name{Local: "foo"}},
+	}
+
+	c := c14n.Canonicalizer{ReplaceInvalidChars: true}
+	out, err := c.Canonicalize(&tokens)
+	fmt.Println(string(out), err)
+	// Output:
+	// <foo>bell�</foo> <nil>
+}
+
+func TestCanonicalize_InvalidChars(t *testing.T) {
+	foo := xml.Name{Local: "foo"}
+	bar := xml.Name{Local: "bar"}
+
+	type testCase struct {
+		Token    xml.Token
+		Err      string
+		Replaced string
+	}
+
+	testCases := []testCase{
+		testCase{
+			Token:    xml.CharData("a\x00b"),
+			Err:      "c14n: invalid character U+0000 at offset 1 of token 2",
+			Replaced: "<foo>a�b</foo>",
+		},
+		testCase{
+			Token:    xml.CharData("é\xffz\xc3"),
+			Err:      "c14n: invalid UTF-8 at offset 2 of token 2",
+			Replaced: "<foo>é�z�</foo>",
+		},
+		testCase{
+			Token:    xml.CharData("￾"),
+			Err:      "c14n: invalid character U+FFFE at offset 0 of token 2",
+			Replaced: "<foo>�</foo>",
+		},
+		testCase{
+			Token:    xml.CharData("\xed\xa0\x80"), // an encoded surrogate
+			Err:      "c14n: invalid UTF-8 at offset 0 of token 2",
+			Replaced: "<foo>���</foo>",
+		},
+		testCase{
+			Token: xml.StartElement{Name: bar, Attr: []xml.Attr{
+				{Name: xml.Name{Local: "a"}, Value: "ok"},
+				{Name: xml.Name{Space: "x", Local: "b"}, Value: "\x1b[0m"},
+			}},
+			Err:      `c14n: invalid character U+001B at offset 0 of attribute "x:b" of token 2`,
+			Replaced: "<foo><bar a=\"ok\" x:b=\"�[0m\"></bar></foo>",
+		},
+		testCase{
+			Token:    xml.Comment("\x0c"),
+			Err:      "c14n: invalid character U+000C at offset 0 of token 2",
+			Replaced: "<foo></foo>",
+		},
+		testCase{
+			Token:    xml.ProcInst{Target: "pi", Inst: []byte("\x7f\x80")},
+			Err:      "c14n: invalid UTF-8 at offset 1 of token 2",
+			Replaced: "<foo><?pi \x7f�?></foo>",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(fmt.Sprintf("%#v", tt.Token), func(t *testing.T) {
+			tokens := func() *c14n.TokenSlice {
+				s := c14n.TokenSlice{xml.StartElement{Name: foo}, tt.Token}
+				if start, ok := tt.Token.(xml.StartElement); ok {
+					s = append(s, xml.EndElement{Name: start.Name})
+				}
+
+				s = append(s, xml.EndElement{Name: foo})
+				return &s
+			}
+
+			_, err := c14n.Canonicalize(tokens())
+			assert.EqualError(t, err, tt.Err)
+			assert.IsType(t, &c14n.InvalidCharError{}, err)
+
+			c := c14n.Canonicalizer{ReplaceInvalidChars: true}
+			out, err := c.Canonicalize(tokens())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Replaced, string(out))
+		})
+	}
+}
+
+func TestCanonicalize_InvalidChars_NotModified(t *testing.T) {
+	attrs := []xml.Attr{{Name: xml.Name{Local: "a"}, Value: "\x01"}}
+	tokens := c14n.TokenSlice{
+		xml.StartElement{Name: xml.Name{Local: "foo"}, Attr: attrs},
+		xml.EndElement{Name: xml.Name{Local: "foo"}},
+	}
+
+	c := c14n.Canonicalizer{ReplaceInvalidChars: true}
+	out, err := c.Canonicalize(&tokens)
+	assert.NoError(t, err)
+	assert.Equal(t, "<foo a=\"�\"></foo>", string(out))
+	assert.Equal(t, "\x01", attrs[0].Value)
+}
+
+func TestCanonicalizeElement_InvalidChars(t *testing.T) {
+	el := &c14n.Element{
+		Name: xml.Name{Local: "foo"},
+		Children: []c14n.Node{
+			c14n.Text("ok"),
+			&c14n.Element{Name: xml.Name{Local: "bar"}, Children: []c14n.Node{c14n.Text("\x02")}},
+		},
+	}
+
+	_, err := c14n.CanonicalizeElement(el)
+	assert.EqualError(t, err, "c14n: invalid character U+0002 at offset 0 of token 4")
+}
+
+func TestCanonicalize_MalformedMarkup(t *testing.T) {
+	foo := xml.Name{Local: "foo"}
+
+	type testCase struct {
+		Token xml.Token
+		Err   string
+	}
+
+	testCases := []testCase{
+		testCase{
+			Token: xml.StartElement{Name: xml.Name{Local: "a\x01"}},
+			Err:   `c14n: malformed token 2: invalid element name "a\x01"`,
+		},
+		testCase{
+			Token: xml.StartElement{Name: xml.Name{Space: "1x", Local: "a"}},
+			Err:   `c14n: malformed token 2: invalid element name "1x:a"`,
+		},
+		testCase{
+			Token: xml.StartElement{Name: xml.Name{Local: "a"}, Attr: []xml.Attr{
+				{Name: xml.Name{Local: "ok"}, Value: "1"},
+				{Name: xml.Name{Local: "b\x02"}, Value: "2"},
+			}},
+			Err: `c14n: malformed token 2: invalid attribute name "b\x02"`,
+		},
+		testCase{
+			Token: xml.StartElement{Name: xml.Name{Local: "a"}, Attr: []xml.Attr{
+				{Name: xml.Name{Local: "b c"}, Value: "1"},
+			}},
+			Err: `c14n: malformed token 2: invalid attribute name "b c"`,
+		},
+		testCase{
+			Token: xml.Comment("x-->y"),
+			Err:   `c14n: malformed token 2: comment contains "--"`,
+		},
+		testCase{
+			Token: xml.Comment("x-"),
+			Err:   `c14n: malformed token 2: comment ends in "-"`,
+		},
+		testCase{
+			Token: xml.ProcInst{Target: "pi", Inst: []byte("?>z")},
+			Err:   `c14n: malformed token 2: processing instruction contains "?>"`,
+		},
+		testCase{
+			Token: xml.ProcInst{Target: "p i", Inst: []byte("z")},
+			Err:   `c14n: malformed token 2: invalid processing instruction target "p i"`,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(fmt.Sprintf("%#v", tt.Token), func(t *testing.T) {
+			tokens := func() *c14n.TokenSlice {
+				s := c14n.TokenSlice{xml.StartElement{Name: foo}, tt.Token}
+				if start, ok := tt.Token.(xml.StartElement); ok {
+					s = append(s, xml.EndElement{Name: start.Name})
+				}
+
+				s = append(s, xml.EndElement{Name: foo})
+				return &s
+			}
+
+			_, err := c14n.Canonicalize(tokens())
+			assert.EqualError(t, err, tt.Err)
+			assert.IsType(t, &c14n.MarkupError{}, err)
+
+			c := c14n.Canonicalizer{ReplaceInvalidChars: true, WithComments: true}
+			_, err = c.Canonicalize(tokens())
+			assert.EqualError(t, err, tt.Err)
+		})
+	}
+}
+
+func TestCanonicalizeElement_MalformedMarkup(t *testing.T) {
+	el := &c14n.Element{
+		Name: xml.Name{Local: "foo"},
+		Children: []c14n.Node{
+			c14n.Comment("ok"),
+			c14n.Comment("not--ok"),
+		},
+	}
+
+	_, err := c14n.CanonicalizeElement(el)
+	assert.EqualError(t, err, `c14n: malformed token 3: comment contains "--"`)
+}
@@ -0,0 +1,173 @@
+package c14n
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/ucarion/c14n/internal/dtd"
+)
+
+// ErrExternalEntity is returned when a document refers to an external parsed
+// entity, and no EntityResolver was provided to obtain its content.
+var ErrExternalEntity = errors.New("c14n: external entity referenced without an EntityResolver")
+
+// EntityResolver obtains the content of external parsed entities, such as
+// those declared by <!ENTITY name SYSTEM "file.txt">. It is only consulted when
+// a Canonicalizer has ProcessDTD set.
+//
+// This package never does I/O on its own account. An EntityResolver decides
+// which entities are available, and from where; MapResolver and FSResolver
+// serve entities from memory or from a file system, respectively.
+type EntityResolver interface {
+	// ResolveEntity returns the content of the entity with the given public
+	// and system identifiers. The public identifier is empty if none was
+	// declared. The content may start with a text declaration, such as
+	// <?xml encoding="UTF-8"?>, and must be encoded in UTF-8.
+	ResolveEntity(publicID, systemID string) ([]byte, error)
+}
+
+// MapResolver is an EntityResolver that serves the content of entities from a
+// map, keyed by system identifier.
+type MapResolver map[string]string
+
+// ResolveEntity implements EntityResolver.
+func (m MapResolver) ResolveEntity(publicID, systemID string) ([]byte, error) {
+	content, ok := m[systemID]
+	if !ok {
+		return nil, fmt.Errorf("c14n: no entity with system identifier %q", systemID)
+	}
+
+	return []byte(content), nil
+}
+
+// FSResolver returns an EntityResolver that reads the content of entities from
+// fsys, treating system identifiers as paths within it. System identifiers
+// that are not valid paths, such as absolute paths or URLs, cannot be
+// resolved.
+func FSResolver(fsys fs.FS) EntityResolver {
+	return fsResolver{fsys: fsys}
+}
+
+type fsResolver struct {
+	fsys fs.FS
+}
+
+func (r fsResolver) ResolveEntity(publicID, systemID string) ([]byte, error) {
+	return fs.ReadFile(r.fsys, systemID)
+}
+
+// entityMarkerEnd ends the marker an xml.Decoder is given in place of the
+// replacement text of each entity.
+const entityMarkerEnd = "\uFDD1"
+
+// newEntityMarker returns the text that begins the marker an xml.Decoder is
+// given in place of the replacement text of each entity: the noncharacter
+// U+FDD0, followed by random digits that a document cannot anticipate, and so
+// cannot forge. The marker continues with the entity's name, and then
+// entityMarkerEnd.
+func newEntityMarker() (string, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", err
+	}
+
+	return "\uFDD0" + hex.EncodeToString(nonce[:]) + ":", nil
+}
+
+// expandEntities replaces the entity markers in the text and attribute values
+// of t with the replacement text of the entities they stand for. Each
+// reference counts toward Limits.MaxEntityExpansion, so that a document cannot
+// amplify itself by referring to a large entity many times.
+func (e *encoder) expandEntities(t xml.Token) (xml.Token, error) {
+	switch t := t.(type) {
+	case xml.StartElement:
+		for i, attr := range t.Attr {
+			if !strings.Contains(attr.Value, e.entityMarker) {
+				continue
+			}
+
+			var err error
+			t = mapAttrValues(t, i, func(s string) string {
+				if err == nil {
+					s, err = e.expandString(s, true)
+				}
+
+				return s
+			})
+
+			return t, err
+		}
+	case xml.CharData:
+		if strings.Contains(string(t), e.entityMarker) {
+			s, err := e.expandString(string(t), false)
+			return xml.CharData(s), err
+		}
+	}
+
+	return t, nil
+}
+
+// expandString replaces the entity markers in s, as expandEntities does. As
+// the XML spec requires, attribute values may not refer to external entities.
+func (e *encoder) expandString(s string, attr bool) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, e.entityMarker)
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+
+		b.WriteString(s[:i])
+		s = s[i+len(e.entityMarker):]
+
+		// The decoder only substitutes whole markers, so the end is present.
+		end := strings.Index(s, entityMarkerEnd)
+		name := s[:end]
+		if attr && e.dtd.Entities[name].SystemID != "" {
+			return "", fmt.Errorf("c14n: reference to external entity &%s; in attribute value", name)
+		}
+
+		value, err := e.entities.Expand(name)
+		if err == dtd.ErrUnexpandable {
+			return "", fmt.Errorf("c14n: cannot expand entity &%s; to text", name)
+		}
+
+		if err != nil {
+			return "", e.entityError(err)
+		}
+
+		s = s[end+len(entityMarkerEnd):]
+
+		e.expansion += len(value)
+		if limit := e.entityLimit(); e.expansion > limit {
+			return "", &LimitError{Limit: "MaxEntityExpansion", Max: limit}
+		}
+
+		b.WriteString(value)
+	}
+}
+
+// resolveEntity obtains the content of an external entity from the
+// EntityResolver, once something refers to it.
+func (e *encoder) resolveEntity(entity *dtd.Entity) ([]byte, error) {
+	if e.entityResolver == nil {
+		return nil, ErrExternalEntity
+	}
+
+	return e.entityResolver.ResolveEntity(entity.PublicID, entity.SystemID)
+}
+
+// entityError translates an error from expanding entities.
+func (e *encoder) entityError(err error) error {
+	if err == dtd.ErrLimit {
+		return &LimitError{Limit: "MaxEntityExpansion", Max: e.entityLimit()}
+	}
+
+	return err
+}
@@ -0,0 +1,152 @@
+package c14n_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleMapResolver() {
+	input := `<!DOCTYPE doc [<!ENTITY ent SYSTEM "world.txt">]><doc>Hello, &ent;!</doc>`
+
+	c := c14n.Canonicalizer{
+		ProcessDTD:     true,
+		EntityResolver: c14n.MapResolver{"world.txt": "world"},
+	}
+
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	fmt.Println(string(out), err)
+	// Output:
+	// <doc>Hello, world!</doc> <nil>
+}
+
+func TestMapResolver(t *testing.T) {
+	r := c14n.MapResolver{"world.txt": "world"}
+
+	content, err := r.ResolveEntity("", "world.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+
+	_, err = r.ResolveEntity("", "other.txt")
+	assert.EqualError(t, err, `c14n: no entity with system identifier "other.txt"`)
+}
+
+func TestFSResolver(t *testing.T) {
+	r := c14n.FSResolver(fstest.MapFS{
+		"world.txt":     &fstest.MapFile{Data: []byte("world")},
+		"dir/earth.txt": &fstest.MapFile{Data: []byte("earth")},
+	})
+
+	content, err := r.ResolveEntity("", "world.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(content))
+
+	content, err = r.ResolveEntity("-//Example//ENTITIES//EN", "dir/earth.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "earth", string(content))
+
+	for _, systemID := range []string{"other.txt", "/world.txt", "../world.txt", "http://example.com/world.txt"} {
+		_, err := r.ResolveEntity("", systemID)
+		assert.Error(t, err, systemID)
+	}
+}
+
+func TestCanonicalizer_EntityResolver(t *testing.T) {
+	input := `<!DOCTYPE doc [
+		<!ENTITY ent SYSTEM "ent.txt">
+		<!ENTITY unparsed SYSTEM "earth.gif" NDATA gif>
+	]><doc>&ent;</doc>`
+
+	// Unparsed entities are never resolved.
+	c := c14n.Canonicalizer{
+		ProcessDTD:     true,
+		EntityResolver: c14n.MapResolver{"ent.txt": `<?xml encoding="UTF-8"?>a &amp; b`},
+	}
+
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>a &amp; b</doc>`, string(out))
+
+	// Errors from the resolver are passed through.
+	c.EntityResolver = c14n.MapResolver{}
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.EqualError(t, err, `c14n: no entity with system identifier "ent.txt"`)
+
+	// Without a resolver, external entities are refused.
+	c.EntityResolver = nil
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.Equal(t, c14n.ErrExternalEntity, err)
+
+	// Unless DTDs aren't processed at all, in which case the decoder rejects
+	// the reference itself.
+	c.ProcessDTD = false
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.Error(t, err)
+}
+
+// countingResolver records the system identifiers it is asked to resolve.
+type countingResolver []string
+
+func (r *countingResolver) ResolveEntity(publicID, systemID string) ([]byte, error) {
+	*r = append(*r, systemID)
+	return []byte("world"), nil
+}
+
+func TestCanonicalizer_EntityResolverLazy(t *testing.T) {
+	input := `<!DOCTYPE doc [
+		<!ENTITY used SYSTEM "used.txt">
+		<!ENTITY unused SYSTEM "unused.txt">
+	]><doc>&used;, &used;!</doc>`
+
+	// Documents that declare external entities but never refer to them need
+	// no resolver.
+	c := c14n.Canonicalizer{ProcessDTD: true}
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(strings.Replace(input, "&used;", "x", -1))))
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>x, x!</doc>`, string(out))
+
+	// Entities are resolved once each, and only if they are referred to.
+	var resolver countingResolver
+	c.EntityResolver = &resolver
+	out, err = c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>world, world!</doc>`, string(out))
+	assert.Equal(t, countingResolver{"used.txt"}, resolver)
+
+	// A Tokenizer resolves entities itself, and the Canonicalizer does not
+	// resolve them again.
+	resolver = nil
+	tokenizer := c14n.NewTokenizer(strings.NewReader(input))
+	tokenizer.EntityResolver = &resolver
+	out, err = c.Canonicalize(tokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc>world, world!</doc>`, string(out))
+	assert.Equal(t, countingResolver{"used.txt"}, resolver)
+
+	// Attribute values may not refer to external entities at all.
+	resolver = nil
+	input = strings.Replace(input, "<doc>", `<doc a="&used;">`, 1)
+	_, err = c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.EqualError(t, err, "c14n: reference to external entity &used; in attribute value")
+	assert.Empty(t, resolver)
+}
+
+func TestCanonicalizer_EntityExpansionLimit(t *testing.T) {
+	input := `<!DOCTYPE doc [<!ENTITY a "aaaaaaaaaa">]><doc b="&a;&a;">&a;&a;&a;&a;&a;&a;&a;&a;</doc>`
+
+	// Every reference in the document counts toward the limit, in attribute
+	// values and text alike.
+	c := c14n.Canonicalizer{ProcessDTD: true, Limits: c14n.Limits{MaxEntityExpansion: 99}}
+	_, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.Equal(t, &c14n.LimitError{Limit: "MaxEntityExpansion", Max: 99}, err)
+
+	c.Limits.MaxEntityExpansion = 100
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(input)))
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc b="`+strings.Repeat("a", 20)+`">`+strings.Repeat("a", 80)+`</doc>`, string(out))
+}
@@ -0,0 +1,61 @@
+package c14n
+
+import "bytes"
+
+// Equal reports whether two sequences of raw XML tokens have the same
+// canonical form, as produced by Canonicalize.
+//
+// Equal canonicalizes both inputs in lockstep, and stops reading as soon as the
+// outputs differ. Only the canonical form of the most recent tokens of each
+// input is held in memory at any given time.
+//
+// If either input returns an error, Equal returns that error.
+func Equal(a, b RawTokenReader) (bool, error) {
+	ca, cb := getCanonicalizer(), getCanonicalizer()
+	defer putCanonicalizer(ca)
+	defer putCanonicalizer(cb)
+
+	ea, eb := &ca.e, &cb.e
+	ea.reset(a)
+	eb.reset(b)
+
+	var doneA, doneB bool
+	for {
+		// Read from each input until it has some output pending, or it's finished
+		// rendering its root element.
+		for ea.buf.Len() == 0 && !doneA {
+			done, err := ea.next()
+			if err != nil {
+				return false, err
+			}
+
+			doneA = done
+		}
+
+		for eb.buf.Len() == 0 && !doneB {
+			done, err := eb.next()
+			if err != nil {
+				return false, err
+			}
+
+			doneB = done
+		}
+
+		// If either side has nothing left to offer, then the outputs are equal if
+		// and only if the other side has also run dry.
+		if ea.buf.Len() == 0 || eb.buf.Len() == 0 {
+			return ea.buf.Len() == eb.buf.Len(), nil
+		}
+
+		// Compare as much output as both sides have available. Whatever is left
+		// over on the longer side will be compared on a later iteration.
+		n := ea.buf.Len()
+		if eb.buf.Len() < n {
+			n = eb.buf.Len()
+		}
+
+		if !bytes.Equal(ea.buf.Next(n), eb.buf.Next(n)) {
+			return false, nil
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package c14n_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleEqual() {
+	a := xml.NewDecoder(strings.NewReader(`<foo z="2" a="1"><bar /></foo>`))
+	b := xml.NewDecoder(strings.NewReader(`<foo a='1' z='2'><bar></bar></foo>`))
+	fmt.Println(c14n.Equal(a, b))
+	// Output:
+	// true <nil>
+}
+
+func TestEqual(t *testing.T) {
+	type testCase struct {
+		A     string
+		B     string
+		Equal bool
+	}
+
+	testCases := []testCase{
+		testCase{
+			A:     `<foo />`,
+			B:     `<foo></foo>`,
+			Equal: true,
+		},
+		testCase{
+			A:     `<foo xmlns:a="http://example.com"><bar /></foo>`,
+			B:     `<foo><bar /></foo>`,
+			Equal: true,
+		},
+		testCase{
+			A:     `<!-- leading --><foo />`,
+			B:     `<foo /><!-- trailing -->`,
+			Equal: true,
+		},
+		testCase{
+			A:     `<foo><bar /></foo>`,
+			B:     `<foo><baz /></foo>`,
+			Equal: false,
+		},
+		testCase{
+			A:     `<foo>ab</foo>`,
+			B:     `<foo>abc</foo>`,
+			Equal: false,
+		},
+		testCase{
+			A:     `<foo />`,
+			B:     `<foo><bar /></foo>`,
+			Equal: false,
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			a := xml.NewDecoder(strings.NewReader(tt.A))
+			b := xml.NewDecoder(strings.NewReader(tt.B))
+
+			equal, err := c14n.Equal(a, b)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Equal, equal)
+
+			// Equality should be symmetric.
+			a = xml.NewDecoder(strings.NewReader(tt.A))
+			b = xml.NewDecoder(strings.NewReader(tt.B))
+
+			equal, err = c14n.Equal(b, a)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Equal, equal)
+		})
+	}
+}
+
+func TestEqual_StopsAtFirstDifference(t *testing.T) {
+	// b is truncated after the point where it differs from a. If Equal kept
+	// reading after the first difference, it would get an error.
+	a := xml.NewDecoder(strings.NewReader(`<foo><bar /><baz /></foo>`))
+	b := xml.NewDecoder(strings.NewReader(`<foo><qux />`))
+
+	equal, err := c14n.Equal(a, b)
+	assert.NoError(t, err)
+	assert.False(t, equal)
+}
+
+func TestEqual_RawTokenError(t *testing.T) {
+	a := xml.NewDecoder(strings.NewReader(`<foo />`))
+
+	_, err := c14n.Equal(a, &errRawTokener{})
+	assert.Equal(t, errDummy, err)
+
+	_, err = c14n.Equal(&errRawTokener{}, a)
+	assert.Equal(t, errDummy, err)
+}
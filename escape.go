@@ -0,0 +1,84 @@
+package c14n
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+// writeName writes out a QName: the local name if the prefix is empty, or
+// otherwise the prefix, a colon, and the local name.
+func writeName(buf *bytes.Buffer, name xml.Name) {
+	if name.Space != "" {
+		buf.WriteString(name.Space)
+		buf.WriteByte(':')
+	}
+
+	buf.WriteString(name.Local)
+}
+
+// escapeText writes out the string value of a text node, escaped as the c14n
+// spec requires: &, <, >, and #xD are replaced by &amp;, &lt;, &gt;, and
+// &#xD; respectively.
+//
+// All of the escaped characters are ASCII, so it's safe to scan the input a
+// byte at a time. Runs of bytes that don't need escaping are copied to the
+// output as-is, so input that needs no escaping is written with a single
+// call to Write.
+func escapeText(buf *bytes.Buffer, s []byte) {
+	last := 0 // the start of the run of bytes not yet written
+	for i, c := range s {
+		var esc string
+		switch c {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			esc = "&gt;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			continue
+		}
+
+		buf.Write(s[last:i])
+		buf.WriteString(esc)
+		last = i + 1
+	}
+
+	buf.Write(s[last:])
+}
+
+// escapeAttr writes out the string value of an attribute node, escaped as the
+// c14n spec requires: &, <, ", #x9, #xA, and #xD are replaced by &amp;, &lt;,
+// &quot;, &#x9;, &#xA;, and &#xD; respectively.
+//
+// As with escapeText, unescaped runs of the input are copied as-is.
+func escapeAttr(buf *bytes.Buffer, s string) {
+	last := 0 // the start of the run of bytes not yet written
+	for i := 0; i < len(s); i++ {
+		var esc string
+		switch s[i] {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '"':
+			esc = "&quot;"
+		case '\t':
+			esc = "&#x9;"
+		case '\n':
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			continue
+		}
+
+		buf.WriteString(s[last:i])
+		buf.WriteString(esc)
+		last = i + 1
+	}
+
+	buf.WriteString(s[last:])
+}
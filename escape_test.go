@@ -0,0 +1,141 @@
+package c14n
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// escapeInputs are strings exercising every escaped character, in various
+// positions.
+var escapeInputs = []string{
+	"",
+	"plain text",
+	"&",
+	"<>",
+	"a&b<c>d\"e\tf\ng\rh",
+	"&&&<<<>>>\"\"\"",
+	"\r\n\t",
+	"trailing&",
+	"&leading",
+	"unicode: ©é  & more",
+	`value>"0" && value<"10" ?"valid":"error"`,
+}
+
+// referenceEscapeText is the straightforward but allocation-heavy
+// implementation of escapeText, against which escapeText is tested.
+func referenceEscapeText(s []byte) []byte {
+	s = bytes.ReplaceAll(s, []byte("&"), []byte("&amp;"))
+	s = bytes.ReplaceAll(s, []byte("<"), []byte("&lt;"))
+	s = bytes.ReplaceAll(s, []byte(">"), []byte("&gt;"))
+	s = bytes.ReplaceAll(s, []byte("\r"), []byte("&#xD;"))
+	return s
+}
+
+// referenceEscapeAttr is the straightforward but allocation-heavy
+// implementation of escapeAttr, against which escapeAttr is tested.
+func referenceEscapeAttr(s string) []byte {
+	val := []byte(s)
+	val = bytes.ReplaceAll(val, []byte("&"), []byte("&amp;"))
+	val = bytes.ReplaceAll(val, []byte("<"), []byte("&lt;"))
+	val = bytes.ReplaceAll(val, []byte("\""), []byte("&quot;"))
+	val = bytes.ReplaceAll(val, []byte("\t"), []byte("&#x9;"))
+	val = bytes.ReplaceAll(val, []byte("\n"), []byte("&#xA;"))
+	val = bytes.ReplaceAll(val, []byte("\r"), []byte("&#xD;"))
+	return val
+}
+
+// randomEscapeInputs returns n pseudo-random strings made mostly of characters
+// that need escaping.
+func randomEscapeInputs(n int) []string {
+	const alphabet = "&<>\"'\t\n\r ab©"
+
+	r := rand.New(rand.NewSource(0))
+	out := make([]string, n)
+	for i := range out {
+		b := make([]byte, r.Intn(32))
+		for j := range b {
+			b[j] = alphabet[r.Intn(len(alphabet))]
+		}
+
+		out[i] = string(b)
+	}
+
+	return out
+}
+
+func TestEscapeText(t *testing.T) {
+	for _, s := range append(escapeInputs, randomEscapeInputs(1000)...) {
+		var buf bytes.Buffer
+		escapeText(&buf, []byte(s))
+		assert.Equal(t, string(referenceEscapeText([]byte(s))), buf.String(), "%q", s)
+	}
+}
+
+func TestEscapeAttr(t *testing.T) {
+	for _, s := range append(escapeInputs, randomEscapeInputs(1000)...) {
+		var buf bytes.Buffer
+		escapeAttr(&buf, s)
+		assert.Equal(t, string(referenceEscapeAttr(s)), buf.String(), "%q", s)
+	}
+}
+
+func TestEscape_NoAllocs(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Grow(1024)
+
+	text := []byte("plain text & <escaped> text\r\n")
+	attr := "plain value & <escaped> \"value\"\t\r\n"
+
+	allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		escapeText(&buf, text)
+		escapeAttr(&buf, attr)
+	})
+
+	assert.Equal(t, 0.0, allocs)
+}
+
+func BenchmarkEscapeText(b *testing.B) {
+	benchmarkEscape(b, func(buf *bytes.Buffer, s string) {
+		escapeText(buf, []byte(s))
+	})
+}
+
+func BenchmarkEscapeText_Reference(b *testing.B) {
+	benchmarkEscape(b, func(buf *bytes.Buffer, s string) {
+		buf.Write(referenceEscapeText([]byte(s)))
+	})
+}
+
+func BenchmarkEscapeAttr(b *testing.B) {
+	benchmarkEscape(b, escapeAttr)
+}
+
+func BenchmarkEscapeAttr_Reference(b *testing.B) {
+	benchmarkEscape(b, func(buf *bytes.Buffer, s string) {
+		buf.Write(referenceEscapeAttr(s))
+	})
+}
+
+func benchmarkEscape(b *testing.B, escape func(*bytes.Buffer, string)) {
+	inputs := map[string]string{
+		"clean": "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+		"dirty": `value>"0" && value<"10" ?"valid":"error"` + "\r\n\t",
+	}
+
+	for name, s := range inputs {
+		b.Run(name, func(b *testing.B) {
+			var buf bytes.Buffer
+			b.ReportAllocs()
+			b.SetBytes(int64(len(s)))
+
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				escape(&buf, s)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package c14n
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Format returns an indented rendering of the first root-level element of a
+// sequence of raw XML tokens. It is like calling FormatElement on the tree
+// Parse returns.
+func Format(r RawTokenReader, indent string) ([]byte, error) {
+	el, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return FormatElement(el, indent)
+}
+
+// FormatElement returns an indented rendering of an element tree, for people
+// to read. Tags, attributes, namespace declarations and text are rendered as
+// CanonicalizeElement renders them, but each child of an element is put on a
+// line of its own, indented by one more copy of indent than its parent. Text
+// made only of whitespace, which such indentation replaces, is dropped, as
+// are comments.
+//
+// Indentation is never added where it would change the document's text: the
+// content of an element that holds text other than whitespace, or that has
+// xml:space="preserve" or inherits it, is rendered exactly as
+// CanonicalizeElement would render it, as are all of its descendants.
+//
+// So documents that differ only in their insignificant whitespace are
+// formatted identically. The output is not canonical XML, and is not
+// generally equal to the canonical form of the input.
+func FormatElement(el *Element, indent string) ([]byte, error) {
+	c := getCanonicalizer()
+	defer putCanonicalizer(c)
+
+	c.e.reset(nil)
+	if err := c.e.formatElement(el, indent, 0, false); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, c.e.buf.Len())
+	copy(out, c.e.buf.Bytes())
+	return out, nil
+}
+
+// formatElement renders an element at the given depth and all of its
+// descendants, indenting its children unless its content is to be rendered
+// as-is. preserve is whether the element inherits xml:space="preserve".
+func (e *encoder) formatElement(el *Element, indent string, depth int, preserve bool) error {
+	for _, attr := range el.Attr {
+		if attr.Name.Space == "xml" && attr.Name.Local == "space" {
+			preserve = attr.Value == "preserve"
+		}
+	}
+
+	if preserve || isMixed(el) {
+		return e.element(el)
+	}
+
+	start := xml.StartElement{Name: el.Name, Attr: make([]xml.Attr, len(el.Attr))}
+	for i, attr := range el.Attr {
+		start.Attr[i] = xml.Attr(attr)
+	}
+
+	if err := e.treeToken(start); err != nil {
+		return err
+	}
+
+	// Every Text child is whitespace, since el is not mixed, and so none of
+	// them are rendered.
+	indented := false
+	for _, child := range el.Children {
+		var err error
+		switch child := child.(type) {
+		case *Element:
+			e.writeIndent(indent, depth+1)
+			err = e.formatElement(child, indent, depth+1, preserve)
+		case PI:
+			if child.Target == "xml" {
+				continue // rendered as nothing, like an XML declaration
+			}
+
+			e.writeIndent(indent, depth+1)
+			err = e.treeToken(xml.ProcInst{Target: child.Target, Inst: []byte(child.Inst)})
+		default:
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		indented = true
+	}
+
+	if indented {
+		e.writeIndent(indent, depth)
+	}
+
+	return e.treeToken(xml.EndElement{Name: el.Name})
+}
+
+// writeIndent starts a new line, indented to the given depth.
+func (e *encoder) writeIndent(indent string, depth int) {
+	e.buf.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		e.buf.WriteString(indent)
+	}
+}
+
+// isMixed returns whether el has mixed content: whether any of its children is
+// text other than whitespace.
+func isMixed(el *Element) bool {
+	for _, child := range el.Children {
+		if text, ok := child.(Text); ok && strings.Trim(string(text), " \t\r\n") != "" {
+			return true
+		}
+	}
+
+	return false
+}
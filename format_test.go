@@ -0,0 +1,106 @@
+package c14n_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+	"golang.org/x/net/html/charset"
+)
+
+func ExampleFormat() {
+	input := `<foo z="2" a="1"><bar><baz /></bar><p>Some <b>bold</b> text</p></foo>`
+	decoder := xml.NewDecoder(strings.NewReader(input))
+	out, err := c14n.Format(decoder, "  ")
+	fmt.Println(string(out), err)
+	// Output:
+	// <foo a="1" z="2">
+	//   <bar>
+	//     <baz></baz>
+	//   </bar>
+	//   <p>Some <b>bold</b> text</p>
+	// </foo> <nil>
+}
+
+func TestFormat(t *testing.T) {
+	testCases := map[string]string{
+		// Whitespace between elements is replaced with indentation.
+		"<a>\n\t<b>  </b>   <c/>\n</a>": "<a>\n\t<b></b>\n\t<c></c>\n</a>",
+
+		// Namespaces are rendered as by the canonicalizer, and attributes sorted.
+		`<a:x xmlns:a="urn:a" xmlns:b="urn:b"><b:y b:z="1" a="2"/></a:x>`: "<a:x xmlns:a=\"urn:a\">\n\t<b:y xmlns:b=\"urn:b\" a=\"2\" b:z=\"1\"></b:y>\n</a:x>",
+
+		// Comments are dropped, and processing instructions indented.
+		"<a><!-- c --><?pi x?></a>": "<a>\n\t<?pi x?>\n</a>",
+
+		// Mixed content, and everything within it, is rendered as-is.
+		"<a> <p>x <b> <i/> </b></p> </a>": "<a>\n\t<p>x <b> <i></i> </b></p>\n</a>",
+
+		// As is the content of an element with xml:space="preserve".
+		"<a><b xml:space=\"preserve\"> <c> <d/> </c> </b></a>": "<a>\n\t<b xml:space=\"preserve\"> <c> <d></d> </c> </b>\n</a>",
+
+		// Even if a descendant restores xml:space="default".
+		"<a xml:space=\"preserve\"><b xml:space=\"default\"> <c/> </b></a>": "<a xml:space=\"preserve\"><b xml:space=\"default\"> <c></c> </b></a>",
+
+		// Elsewhere, xml:space="default" changes nothing.
+		"<a xml:space=\"default\"> <b> <c/> </b></a>": "<a xml:space=\"default\">\n\t<b>\n\t\t<c></c>\n\t</b>\n</a>",
+	}
+
+	for in, out := range testCases {
+		t.Run(in, func(t *testing.T) {
+			actual, err := c14n.Format(xml.NewDecoder(strings.NewReader(in)), "\t")
+			assert.NoError(t, err)
+			assert.Equal(t, out, string(actual))
+		})
+	}
+}
+
+func TestFormat_Deterministic(t *testing.T) {
+	// Formatting is idempotent, and its output canonicalizes like its input,
+	// once insignificant whitespace is trimmed from both.
+	c := c14n.Canonicalizer{TrimTextNodes: true}
+	for _, tt := range readTestCases(t) {
+		t.Run(tt.Name, func(t *testing.T) {
+			if !tt.Options.isDefault() {
+				t.Skip("test case uses options")
+			}
+
+			decoder := xml.NewDecoder(bytes.NewReader(tt.In))
+			decoder.CharsetReader = charset.NewReaderLabel
+
+			formatted, err := c14n.Format(decoder, "  ")
+			assert.NoError(t, err)
+
+			again, err := c14n.Format(xml.NewDecoder(bytes.NewReader(formatted)), "  ")
+			assert.NoError(t, err)
+			assert.Equal(t, string(formatted), string(again))
+
+			want, err := c.Canonicalize(xml.NewDecoder(bytes.NewReader(tt.Out)))
+			assert.NoError(t, err)
+
+			actual, err := c.Canonicalize(xml.NewDecoder(bytes.NewReader(formatted)))
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), string(actual))
+		})
+	}
+}
+
+func TestFormat_Unbalanced(t *testing.T) {
+	decoder := xml.NewDecoder(strings.NewReader("</foo><foo></foo>"))
+	_, err := c14n.Format(decoder, "  ")
+	assert.Equal(t, c14n.ErrUnbalanced, err)
+}
+
+func TestFormatElement_InvalidChars(t *testing.T) {
+	el := &c14n.Element{
+		Name:     xml.Name{Local: "foo"},
+		Children: []c14n.Node{&c14n.Element{Name: xml.Name{Local: "bar"}, Children: []c14n.Node{c14n.Text("\x02")}}},
+	}
+
+	_, err := c14n.FormatElement(el, "  ")
+	assert.EqualError(t, err, "c14n: invalid character U+0002 at offset 0 of token 3")
+}
@@ -0,0 +1,88 @@
+package c14n_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ucarion/c14n"
+)
+
+// addFuzzSeeds adds the inputs of the tests directory, and a few edge cases, to
+// the seed corpus of f.
+func addFuzzSeeds(f *testing.F) {
+	paths, err := filepath.Glob("tests/*/in.xml")
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	for _, path := range paths {
+		in, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(in)
+	}
+
+	f.Add([]byte(`</a>`))
+	f.Add([]byte(`<a></b>`))
+	f.Add([]byte(`<a:b c:d="e"></a:b>`))
+	f.Add([]byte(`<a xmlns=""><b xmlns="http://example.com"><c xmlns=""></c></b></a>`))
+	f.Add([]byte(`<a>&#xD;&#x9;<![CDATA[]]>]]&gt;</a>`))
+}
+
+// FuzzCanonicalize checks that canonical output is well-formed, and that
+// canonicalization is idempotent.
+func FuzzCanonicalize(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		out, err := c14n.Canonicalize(xml.NewDecoder(bytes.NewReader(in)))
+		if err != nil {
+			return
+		}
+
+		// The output must be well-formed. Token, unlike RawToken, checks that
+		// start and end elements match.
+		decoder := xml.NewDecoder(bytes.NewReader(out))
+		for {
+			_, err := decoder.Token()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatalf("output does not reparse: %v\ninput:  %q\noutput: %q", err, in, out)
+			}
+		}
+
+		// Canonicalizing the output must not change it.
+		again, err := c14n.Canonicalize(xml.NewDecoder(bytes.NewReader(out)))
+		if err != nil {
+			t.Fatalf("output does not canonicalize: %v\ninput:  %q\noutput: %q", err, in, out)
+		}
+
+		if !bytes.Equal(out, again) {
+			t.Fatalf("canonicalization is not idempotent\ninput:  %q\nonce:   %q\ntwice:  %q", in, out, again)
+		}
+	})
+}
+
+// FuzzCanonicalize_NonStrict checks that Canonicalize does not panic, even on
+// the malformed token sequences a non-strict xml.Decoder produces.
+func FuzzCanonicalize_NonStrict(f *testing.F) {
+	addFuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		decoder := xml.NewDecoder(bytes.NewReader(in))
+		decoder.Strict = false
+		decoder.AutoClose = xml.HTMLAutoClose
+		decoder.Entity = xml.HTMLEntity
+
+		c14n.Canonicalize(decoder)
+	})
+}
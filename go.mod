@@ -1,8 +1,15 @@
 module github.com/ucarion/c14n
 
-go 1.14
+go 1.18
 
 require (
 	github.com/stretchr/testify v1.5.1
 	golang.org/x/net v0.0.0-20200506145744-7e3656a0809f
+	golang.org/x/text v0.22.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
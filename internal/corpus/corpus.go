@@ -0,0 +1,136 @@
+// Package corpus generates synthetic XML documents for benchmarking.
+//
+// Each generator is deterministic: calling it twice with the same arguments
+// returns the same document.
+package corpus
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// SAMLAssertion returns a small, signed SAML response, representative of what
+// an identity provider issues for a single login.
+func SAMLAssertion() []byte {
+	return []byte(samlAssertion)
+}
+
+const samlAssertion = `<samlp:Response xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" ID="root" Version="2.0" IssueInstant="2020-05-26T00:24:42Z" Destination="http://sp.example.com">
+  <saml:Issuer>http://idp.example.com</saml:Issuer>
+  <ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#">
+    <ds:SignedInfo>
+      <ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#" />
+      <ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256" />
+      <ds:Reference URI="#root">
+        <ds:Transforms>
+          <ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature" />
+          <ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#" />
+        </ds:Transforms>
+        <ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256" />
+        <ds:DigestValue>ZGlnZXN0IHZhbHVlIGdvZXMgaGVyZQ==</ds:DigestValue>
+      </ds:Reference>
+    </ds:SignedInfo>
+    <ds:SignatureValue>c2lnbmF0dXJlIHZhbHVlIGdvZXMgaGVyZQ==</ds:SignatureValue>
+  </ds:Signature>
+  <samlp:Status>
+    <samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success" />
+  </samlp:Status>
+  <saml:Assertion xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" Version="2.0" ID="Ad16bfaaa9436509463d25f8590385aed135abef5" IssueInstant="2020-05-26T00:24:42Z">
+    <saml:Issuer>http://idp.example.com</saml:Issuer>
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">jdoe@example.com</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData NotOnOrAfter="2020-05-26T00:27:42Z" Recipient="http://sp.example.com" />
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotBefore="2020-05-26T00:21:42Z" NotOnOrAfter="2020-05-26T00:27:42Z">
+      <saml:AudienceRestriction>
+        <saml:Audience>http://sp.example.com</saml:Audience>
+      </saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AuthnStatement AuthnInstant="2020-05-26T00:24:41Z" SessionNotOnOrAfter="2020-05-27T00:24:42Z" SessionIndex="aaa">
+      <saml:AuthnContext>
+        <saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport</saml:AuthnContextClassRef>
+      </saml:AuthnContext>
+    </saml:AuthnStatement>
+    <saml:AttributeStatement>
+      <saml:Attribute NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:basic" Name="firstName">
+        <saml:AttributeValue xsi:type="xs:string">John</saml:AttributeValue>
+      </saml:Attribute>
+      <saml:Attribute NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:basic" Name="lastName">
+        <saml:AttributeValue xsi:type="xs:string">Doe</saml:AttributeValue>
+      </saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+
+// Flat returns a document whose root element has n children, each with a few
+// attributes and a short run of text.
+func Flat(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<root>\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "  <item z=\"%d\" id=\"item-%d\" name=\"Item %d\">Some text for item %d.</item>\n", n-i, i, i, i)
+	}
+
+	buf.WriteString("</root>")
+	return buf.Bytes()
+}
+
+// Deep returns a document of nested elements, depth elements deep. Every level
+// declares a namespace, which the innermost element uses.
+func Deep(depth int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&buf, "<n%d:e xmlns:n%d=\"http://example.com/%d\" a=\"%d\">", i, i, i, i)
+	}
+
+	buf.WriteString("<leaf")
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&buf, " n%d:a=\"%d\"", i, i)
+	}
+
+	buf.WriteString(" />")
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&buf, "</n%d:e>", i)
+	}
+
+	return buf.Bytes()
+}
+
+// Namespaces returns a document resembling a WS-Security envelope: the root
+// element declares n namespaces, and each of n children redeclares and uses
+// a handful of them.
+func Namespaces(n int) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<soap:Envelope xmlns:soap=\"http://schemas.xmlsoap.org/soap/envelope/\"")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, " xmlns:ns%d=\"urn:example:ns%d\"", i, i)
+	}
+
+	buf.WriteString(">\n")
+	for i := 0; i < n; i++ {
+		j, k := (i+1)%n, (i+2)%n
+		fmt.Fprintf(&buf, "  <ns%d:Part xmlns:ns%d=\"urn:example:ns%d\" ns%d:id=\"%d\" ns%d:ref=\"#%d\"><ns%d:Value>%d</ns%d:Value></ns%d:Part>\n", i, j, j, j, i, k, k, i, i, i, i)
+	}
+
+	buf.WriteString("</soap:Envelope>")
+	return buf.Bytes()
+}
+
+// Escaping returns a document with n elements whose text and attribute values
+// are dense with characters that canonicalization must escape.
+func Escaping(n int) []byte {
+	text := strings.Repeat("a &amp; b &lt; c &gt; d&#xD;\n", 4)
+	attr := strings.Repeat("&quot;x&quot; &amp; &lt;y&gt;&#x9;&#xA;&#xD;", 4)
+
+	var buf bytes.Buffer
+	buf.WriteString("<root>\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "  <e v=\"%s\"><![CDATA[<%d> & \"%d\"]]>%s</e>\n", attr, i, i, text)
+	}
+
+	buf.WriteString("</root>")
+	return buf.Bytes()
+}
@@ -0,0 +1,46 @@
+package corpus_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n/internal/corpus"
+)
+
+func TestCorpus(t *testing.T) {
+	docs := map[string][]byte{
+		"saml":       corpus.SAMLAssertion(),
+		"flat":       corpus.Flat(100),
+		"deep":       corpus.Deep(100),
+		"namespaces": corpus.Namespaces(100),
+		"escaping":   corpus.Escaping(100),
+	}
+
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			// Use Token rather than RawToken, so that the decoder checks that
+			// elements are balanced.
+			decoder := xml.NewDecoder(bytes.NewReader(doc))
+			for {
+				_, err := decoder.Token()
+				if err == io.EOF {
+					break
+				}
+
+				if !assert.NoError(t, err) {
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestCorpus_Deterministic(t *testing.T) {
+	assert.Equal(t, corpus.Flat(10), corpus.Flat(10))
+	assert.Equal(t, corpus.Deep(10), corpus.Deep(10))
+	assert.Equal(t, corpus.Namespaces(10), corpus.Namespaces(10))
+	assert.Equal(t, corpus.Escaping(10), corpus.Escaping(10))
+}
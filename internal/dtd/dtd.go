@@ -0,0 +1,828 @@
+// Package dtd parses the internal subset of a document type declaration, as
+// returned by xml.Decoder in an xml.Directive token.
+//
+// Only the declarations that affect the content of a document are retained:
+// entity declarations and attribute-list declarations. Element and notation
+// declarations, comments and processing instructions are checked for
+// well-formedness and then discarded.
+//
+// https://www.w3.org/TR/xml/#sec-prolog-dtd
+package dtd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ucarion/c14n/internal/xmlchar"
+)
+
+// ErrLimit is returned by an Expander when expanding entities would produce
+// more replacement text than allowed.
+var ErrLimit = errors.New("dtd: entity expansion limit exceeded")
+
+// DTD is a document type declaration.
+type DTD struct {
+	// Name is the declared name of the root element.
+	Name string
+
+	// Entities holds the general entities declared in the internal subset, by
+	// name. Per the XML spec, when an entity is declared more than once, the
+	// first declaration is the one that applies.
+	Entities map[string]*Entity
+
+	// Attlists holds the attribute definitions declared in the internal
+	// subset, by element name. Names hold prefixes, not namespace URIs. When an
+	// attribute is declared more than once for the same element, the first
+	// declaration is the one that applies.
+	Attlists map[xml.Name][]*AttDef
+
+	// Truncated is true if the internal subset references a parameter entity.
+	// A processor that does not read parameter entities must not process any
+	// declarations that follow such a reference, and so those declarations
+	// are not included.
+	Truncated bool
+}
+
+// Entity is a general entity declaration.
+type Entity struct {
+	Name string
+
+	// Value is the replacement text of an internal entity, with character
+	// references resolved. Entity references within it are not expanded.
+	Value string
+
+	// External is true if the entity was declared with a SYSTEM or PUBLIC
+	// identifier, rather than a literal value.
+	External bool
+	SystemID string
+	PublicID string
+
+	// NData is the notation of an unparsed entity.
+	NData string
+}
+
+// AttDef is an attribute definition, from an attribute-list declaration.
+type AttDef struct {
+	Name xml.Name
+
+	// Type is the declared type of the attribute: "CDATA", one of the
+	// tokenized types such as "ID" or "NMTOKENS", "NOTATION", or
+	// "ENUMERATION" for an enumerated type.
+	Type string
+
+	// Default is the attribute's default value, if HasDefault is true. Parse
+	// leaves it as written; NormalizeDefaults normalizes it.
+	Default    string
+	HasDefault bool
+}
+
+// Lookup returns the definition of an attribute of an element, or nil if there
+// is none.
+func (d *DTD) Lookup(element, attr xml.Name) *AttDef {
+	for _, def := range d.Attlists[element] {
+		if def.Name == attr {
+			return def
+		}
+	}
+
+	return nil
+}
+
+// IsDoctype returns whether a directive is a document type declaration.
+func IsDoctype(directive []byte) bool {
+	return bytes.HasPrefix(directive, []byte("DOCTYPE"))
+}
+
+// Parse parses a document type declaration, without the leading "<!" and
+// trailing ">".
+func Parse(directive []byte) (*DTD, error) {
+	p := parser{s: string(directive)}
+	d := &DTD{Entities: map[string]*Entity{}, Attlists: map[xml.Name][]*AttDef{}}
+
+	if !p.consume("DOCTYPE") || !p.space() {
+		return nil, p.errorf("expected DOCTYPE")
+	}
+
+	var err error
+	if d.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+
+	if p.space() && p.peekExternalID() {
+		if _, _, err := p.externalID(); err != nil {
+			return nil, err
+		}
+
+		p.space()
+	}
+
+	if p.consume("[") {
+		if err := p.internalSubset(d); err != nil {
+			return nil, err
+		}
+
+		p.space()
+	}
+
+	if p.i != len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.i:])
+	}
+
+	return d, nil
+}
+
+// parser scans the text of a document type declaration.
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("dtd: offset %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+// consume advances past prefix, and returns true, if the text at the current
+// position starts with it.
+func (p *parser) consume(prefix string) bool {
+	if strings.HasPrefix(p.s[p.i:], prefix) {
+		p.i += len(prefix)
+		return true
+	}
+
+	return false
+}
+
+// space advances past any whitespace, and returns whether there was any.
+func (p *parser) space() bool {
+	start := p.i
+	for p.i < len(p.s) && xmlchar.IsSpace(rune(p.s[p.i])) {
+		p.i++
+	}
+
+	return p.i > start
+}
+
+// name scans an XML Name.
+func (p *parser) name() (string, error) {
+	start := p.i
+	for p.i < len(p.s) {
+		r, size := utf8.DecodeRuneInString(p.s[p.i:])
+		if !xmlchar.IsNameRune(r, p.i == start) {
+			break
+		}
+
+		p.i += size
+	}
+
+	if p.i == start {
+		return "", p.errorf("expected a name")
+	}
+
+	return p.s[start:p.i], nil
+}
+
+// quoted scans a single- or double-quoted literal, and returns its contents.
+func (p *parser) quoted() (string, error) {
+	if p.i == len(p.s) || (p.s[p.i] != '"' && p.s[p.i] != '\'') {
+		return "", p.errorf("expected a quoted literal")
+	}
+
+	quote := p.s[p.i]
+	end := strings.IndexByte(p.s[p.i+1:], quote)
+	if end < 0 {
+		return "", p.errorf("unterminated literal")
+	}
+
+	value := p.s[p.i+1 : p.i+1+end]
+	p.i += end + 2
+	return value, nil
+}
+
+// peekExternalID returns whether an ExternalID starts at the current position.
+func (p *parser) peekExternalID() bool {
+	rest := p.s[p.i:]
+	return strings.HasPrefix(rest, "SYSTEM") || strings.HasPrefix(rest, "PUBLIC")
+}
+
+// externalID scans a SYSTEM or PUBLIC identifier.
+func (p *parser) externalID() (systemID, publicID string, err error) {
+	switch {
+	case p.consume("SYSTEM"):
+	case p.consume("PUBLIC"):
+		if !p.space() {
+			return "", "", p.errorf("expected whitespace")
+		}
+
+		if publicID, err = p.quoted(); err != nil {
+			return "", "", err
+		}
+	default:
+		return "", "", p.errorf("expected SYSTEM or PUBLIC")
+	}
+
+	if !p.space() {
+		return "", "", p.errorf("expected whitespace")
+	}
+
+	if systemID, err = p.quoted(); err != nil {
+		return "", "", err
+	}
+
+	return systemID, publicID, nil
+}
+
+// internalSubset scans the declarations of the internal subset, through the
+// closing bracket.
+func (p *parser) internalSubset(d *DTD) error {
+	for {
+		p.space()
+
+		switch {
+		case p.i == len(p.s):
+			return p.errorf("unterminated internal subset")
+		case p.consume("]"):
+			return nil
+		case p.consume("<!ENTITY"):
+			entity, err := p.entityDecl()
+			if err != nil {
+				return err
+			}
+
+			if entity != nil && !d.Truncated && d.Entities[entity.Name] == nil {
+				d.Entities[entity.Name] = entity
+			}
+		case p.consume("<!ATTLIST"):
+			element, defs, err := p.attlistDecl()
+			if err != nil {
+				return err
+			}
+
+			if d.Truncated {
+				continue
+			}
+
+			for _, def := range defs {
+				if d.Lookup(element, def.Name) == nil {
+					d.Attlists[element] = append(d.Attlists[element], def)
+				}
+			}
+		case p.consume("<!ELEMENT"), p.consume("<!NOTATION"):
+			if err := p.skipDecl(); err != nil {
+				return err
+			}
+		case p.consume("<?"):
+			if err := p.skipPast("?>"); err != nil {
+				return err
+			}
+		case p.consume("<!--"):
+			if err := p.skipPast("-->"); err != nil {
+				return err
+			}
+		case p.consume("%"):
+			if _, err := p.name(); err != nil {
+				return err
+			}
+
+			if !p.consume(";") {
+				return p.errorf("expected ';'")
+			}
+
+			d.Truncated = true
+		default:
+			return p.errorf("unexpected %q", p.s[p.i:p.i+1])
+		}
+	}
+}
+
+// entityDecl scans the rest of an entity declaration. It returns nil for
+// parameter entity declarations, which are not retained.
+func (p *parser) entityDecl() (*Entity, error) {
+	if !p.space() {
+		return nil, p.errorf("expected whitespace")
+	}
+
+	parameter := p.consume("%")
+	if parameter && !p.space() {
+		return nil, p.errorf("expected whitespace")
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.space() {
+		return nil, p.errorf("expected whitespace")
+	}
+
+	entity := &Entity{Name: name}
+	if p.peekExternalID() {
+		entity.External = true
+		if entity.SystemID, entity.PublicID, err = p.externalID(); err != nil {
+			return nil, err
+		}
+
+		if p.space() && p.consume("NDATA") {
+			if parameter || !p.space() {
+				return nil, p.errorf("unexpected NDATA")
+			}
+
+			if entity.NData, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		literal, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+
+		if entity.Value, err = p.entityValue(literal); err != nil {
+			return nil, err
+		}
+	}
+
+	p.space()
+	if !p.consume(">") {
+		return nil, p.errorf("expected '>'")
+	}
+
+	if parameter {
+		return nil, nil
+	}
+
+	return entity, nil
+}
+
+// attlistDecl scans the rest of an attribute-list declaration.
+func (p *parser) attlistDecl() (xml.Name, []*AttDef, error) {
+	if !p.space() {
+		return xml.Name{}, nil, p.errorf("expected whitespace")
+	}
+
+	element, err := p.qualifiedName()
+	if err != nil {
+		return xml.Name{}, nil, err
+	}
+
+	var defs []*AttDef
+	for {
+		hadSpace := p.space()
+		if p.consume(">") {
+			return element, defs, nil
+		}
+
+		if !hadSpace {
+			return xml.Name{}, nil, p.errorf("expected whitespace")
+		}
+
+		def, err := p.attDef()
+		if err != nil {
+			return xml.Name{}, nil, err
+		}
+
+		defs = append(defs, def)
+	}
+}
+
+// tokenizedTypes are the attribute types whose values are normalized.
+var tokenizedTypes = map[string]bool{
+	"ID":       true,
+	"IDREF":    true,
+	"IDREFS":   true,
+	"ENTITY":   true,
+	"ENTITIES": true,
+	"NMTOKEN":  true,
+	"NMTOKENS": true,
+}
+
+// attDef scans an attribute definition.
+func (p *parser) attDef() (*AttDef, error) {
+	name, err := p.qualifiedName()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.space() {
+		return nil, p.errorf("expected whitespace")
+	}
+
+	def := &AttDef{Name: name}
+	if p.consume("(") {
+		def.Type = "ENUMERATION"
+		if err := p.skipPast(")"); err != nil {
+			return nil, err
+		}
+	} else {
+		if def.Type, err = p.name(); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case def.Type == "CDATA", tokenizedTypes[def.Type]:
+		case def.Type == "NOTATION":
+			if !p.space() || !p.consume("(") {
+				return nil, p.errorf("expected '('")
+			}
+
+			if err := p.skipPast(")"); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, p.errorf("unknown attribute type %q", def.Type)
+		}
+	}
+
+	if !p.space() {
+		return nil, p.errorf("expected whitespace")
+	}
+
+	switch {
+	case p.consume("#REQUIRED"), p.consume("#IMPLIED"):
+		return def, nil
+	case p.consume("#FIXED"):
+		if !p.space() {
+			return nil, p.errorf("expected whitespace")
+		}
+	}
+
+	if def.Default, err = p.quoted(); err != nil {
+		return nil, err
+	}
+
+	def.HasDefault = true
+	return def, nil
+}
+
+// qualifiedName scans a Name, and splits it into a prefix and local name.
+func (p *parser) qualifiedName() (xml.Name, error) {
+	name, err := p.name()
+	if err != nil {
+		return xml.Name{}, err
+	}
+
+	if i := strings.IndexByte(name, ':'); i > 0 {
+		return xml.Name{Space: name[:i], Local: name[i+1:]}, nil
+	}
+
+	return xml.Name{Local: name}, nil
+}
+
+// entityValue computes the replacement text of an entity from its literal
+// value, by resolving character references. Entity references are left
+// as-is, to be expanded where the entity is used.
+//
+// https://www.w3.org/TR/xml/#intern-replacement
+func (p *parser) entityValue(literal string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(literal); {
+		switch literal[i] {
+		case '%':
+			return "", p.errorf("parameter entity reference in entity value")
+		case '&':
+			end := strings.IndexByte(literal[i:], ';')
+			if end < 0 {
+				return "", p.errorf("unterminated reference in entity value")
+			}
+
+			ref := literal[i+1 : i+end]
+			if strings.HasPrefix(ref, "#") {
+				r, ok := charRef(ref[1:])
+				if !ok {
+					return "", p.errorf("invalid character reference &%s;", ref)
+				}
+
+				b.WriteRune(r)
+			} else {
+				b.WriteString(literal[i : i+end+1])
+			}
+
+			i += end + 1
+		default:
+			b.WriteByte(literal[i])
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+// skipDecl skips to the end of a markup declaration, ignoring any '>' within
+// quoted literals.
+func (p *parser) skipDecl() error {
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case '"', '\'':
+			if _, err := p.quoted(); err != nil {
+				return err
+			}
+		case '>':
+			p.i++
+			return nil
+		default:
+			p.i++
+		}
+	}
+
+	return p.errorf("unterminated declaration")
+}
+
+// skipPast skips to just after the next occurrence of end.
+func (p *parser) skipPast(end string) error {
+	n := strings.Index(p.s[p.i:], end)
+	if n < 0 {
+		return p.errorf("expected %q", end)
+	}
+
+	p.i += n + len(end)
+	return nil
+}
+
+// Expander expands the general entities of a DTD to text, on demand. Each
+// entity is expanded at most once, and the content of an external entity is
+// only obtained once something refers to it.
+type Expander struct {
+	dtd     *DTD
+	limit   int
+	resolve func(*Entity) ([]byte, error)
+	size    int // the total size of the replacement text produced so far
+
+	expanded map[string]string
+	state    map[string]expandState
+}
+
+// NewExpander returns an Expander for the entities of d.
+//
+// The content of external parsed entities is obtained by calling resolve. If
+// resolve is nil, external entities cannot be expanded. Any error from resolve
+// is returned as-is. Unparsed entities are never expanded.
+//
+// If limit is positive, the Expander returns ErrLimit once the total size of
+// the replacement text it has produced exceeds limit bytes. This guards
+// against entities that expand exponentially, such as "billion laughs".
+func (d *DTD) NewExpander(limit int, resolve func(*Entity) ([]byte, error)) *Expander {
+	return &Expander{
+		dtd:      d,
+		limit:    limit,
+		resolve:  resolve,
+		expanded: map[string]string{},
+		state:    map[string]expandState{},
+	}
+}
+
+// Expand returns the fully expanded replacement text of the named entity.
+//
+// Entities whose replacement text contains markup, or which refer to
+// unexpandable or undeclared entities, cannot be substituted with text, and
+// produce ErrUnexpandable. An entity that refers to itself, directly or
+// indirectly, is an error.
+func (x *Expander) Expand(name string) (string, error) {
+	return x.expand(name)
+}
+
+// ErrUnexpandable is returned by Expander.Expand for an entity that cannot be
+// expanded to text alone.
+var ErrUnexpandable = errors.New("dtd: entity cannot be expanded to text")
+
+type expandState int
+
+const (
+	unvisited expandState = iota
+	expanding
+	expanded
+	unexpandable
+)
+
+// expand returns the fully expanded replacement text of an entity.
+func (x *Expander) expand(name string) (string, error) {
+	switch x.state[name] {
+	case expanding:
+		return "", fmt.Errorf("dtd: entity %q refers to itself", name)
+	case expanded:
+		return x.expanded[name], nil
+	case unexpandable:
+		return "", ErrUnexpandable
+	}
+
+	entity := x.dtd.Entities[name]
+	if entity == nil || entity.NData != "" || (entity.External && x.resolve == nil) {
+		x.state[name] = unexpandable
+		return "", ErrUnexpandable
+	}
+
+	replacement := entity.Value
+	if entity.External {
+		content, err := x.resolve(entity)
+		if err != nil {
+			return "", err
+		}
+
+		replacement = StripTextDecl(string(content))
+	}
+
+	x.state[name] = expanding
+	value, err := x.expandValue(replacement)
+	if err != nil {
+		if err == ErrUnexpandable {
+			x.state[name] = unexpandable
+		}
+
+		return "", err
+	}
+
+	x.state[name] = expanded
+	x.expanded[name] = value
+	return value, nil
+}
+
+// expandValue expands the references in an entity's replacement text.
+func (x *Expander) expandValue(value string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		var s string
+		switch value[i] {
+		case '<':
+			return "", ErrUnexpandable
+		case '&':
+			end := strings.IndexByte(value[i:], ';')
+			if end < 0 {
+				return "", ErrUnexpandable
+			}
+
+			ref := value[i+1 : i+end]
+			i += end + 1
+
+			if strings.HasPrefix(ref, "#") {
+				r, ok := charRef(ref[1:])
+				if !ok {
+					return "", fmt.Errorf("dtd: invalid character reference &%s;", ref)
+				}
+
+				s = string(r)
+			} else if predefined, ok := xmlchar.PredefinedEntities[ref]; ok {
+				s = predefined
+			} else {
+				var err error
+				if s, err = x.expand(ref); err != nil {
+					return "", err
+				}
+			}
+		default:
+			s = value[i : i+1]
+			i++
+		}
+
+		x.size += len(s)
+		if x.limit > 0 && x.size > x.limit {
+			return "", ErrLimit
+		}
+
+		b.WriteString(s)
+	}
+
+	return b.String(), nil
+}
+
+// NormalizeDefaults normalizes the default values of the attributes declared in
+// d, as an XML processor normalizes attribute values: references are replaced
+// with the characters they refer to, whitespace characters are replaced with
+// spaces, and values of types other than CDATA are then normalized with
+// NormalizeValue. Entity references are expanded with x. References to
+// external entities are an error, as the XML spec requires of attribute
+// values.
+//
+// https://www.w3.org/TR/xml/#AVNormalize
+func (d *DTD) NormalizeDefaults(x *Expander) error {
+	for _, defs := range d.Attlists {
+		for _, def := range defs {
+			if !def.HasDefault {
+				continue
+			}
+
+			value, err := x.normalizeLiteral(def.Default)
+			if err == ErrLimit {
+				return err
+			}
+
+			if err != nil {
+				return fmt.Errorf("dtd: default value of attribute %q: %v", def.Name.Local, err)
+			}
+
+			if def.Type != "CDATA" {
+				value = NormalizeValue(value)
+			}
+
+			def.Default = value
+		}
+	}
+
+	return nil
+}
+
+// normalizeLiteral replaces the references and whitespace characters in an
+// attribute value literal.
+func (x *Expander) normalizeLiteral(literal string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(literal); {
+		switch c := literal[i]; {
+		case c == '<':
+			return "", errors.New("'<' in attribute value")
+		case c == '&':
+			end := strings.IndexByte(literal[i:], ';')
+			if end < 0 {
+				return "", errors.New("unterminated reference")
+			}
+
+			ref := literal[i+1 : i+end]
+			i += end + 1
+
+			if strings.HasPrefix(ref, "#") {
+				r, ok := charRef(ref[1:])
+				if !ok {
+					return "", fmt.Errorf("invalid character reference &%s;", ref)
+				}
+
+				b.WriteRune(r)
+			} else if s, ok := xmlchar.PredefinedEntities[ref]; ok {
+				b.WriteString(s)
+			} else if entity := x.dtd.Entities[ref]; entity != nil && entity.External {
+				return "", fmt.Errorf("reference to external entity &%s;", ref)
+			} else if s, err := x.Expand(ref); err == nil {
+				b.WriteString(s)
+			} else if err == ErrUnexpandable {
+				return "", fmt.Errorf("cannot expand entity &%s;", ref)
+			} else {
+				return "", err
+			}
+		case xmlchar.IsSpace(rune(c)):
+			b.WriteByte(' ')
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	return b.String(), nil
+}
+
+// NormalizeValue applies the additional normalization required of attributes
+// whose type is not CDATA: leading and trailing spaces are removed, and runs of
+// spaces are replaced by a single space. Only spaces are affected, not other
+// whitespace characters, which in a normalized value can only have come from
+// character references.
+func NormalizeValue(value string) string {
+	value = strings.Trim(value, " ")
+	if !strings.Contains(value, "  ") {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == ' ' && value[i-1] == ' ' {
+			continue
+		}
+
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
+
+// StripTextDecl removes the text declaration, such as
+// <?xml encoding="UTF-8"?>, that may begin an external parsed entity. It is
+// not part of the entity's replacement text.
+//
+// https://www.w3.org/TR/xml/#sec-TextDecl
+func StripTextDecl(content string) string {
+	if !strings.HasPrefix(content, "<?xml") || len(content) == 5 || !xmlchar.IsSpace(rune(content[5])) {
+		return content
+	}
+
+	end := strings.Index(content, "?>")
+	if end < 0 {
+		return content
+	}
+
+	return content[end+2:]
+}
+
+// charRef parses the digits of a character reference, such as "x20" or "32".
+func charRef(digits string) (rune, bool) {
+	base := 10
+	if strings.HasPrefix(digits, "x") {
+		base, digits = 16, digits[1:]
+	}
+
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || !xmlchar.IsChar(rune(n)) {
+		return 0, false
+	}
+
+	return rune(n), true
+}
@@ -0,0 +1,303 @@
+package dtd_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n/internal/dtd"
+)
+
+func TestParse(t *testing.T) {
+	type testCase struct {
+		In  string
+		Out *dtd.DTD
+	}
+
+	testCases := []testCase{
+		testCase{
+			In:  `DOCTYPE doc`,
+			Out: &dtd.DTD{Name: "doc", Entities: map[string]*dtd.Entity{}, Attlists: map[xml.Name][]*dtd.AttDef{}},
+		},
+		testCase{
+			In:  `DOCTYPE doc SYSTEM "doc.dtd"`,
+			Out: &dtd.DTD{Name: "doc", Entities: map[string]*dtd.Entity{}, Attlists: map[xml.Name][]*dtd.AttDef{}},
+		},
+		testCase{
+			In: `DOCTYPE doc PUBLIC "-//Example//DTD Doc//EN" "doc.dtd" [
+				<!ELEMENT doc (#PCDATA)>
+				<!ATTLIST doc attr CDATA "a > b">
+				<!ATTLIST doc
+					id ID #IMPLIED
+					a:names NMTOKENS #FIXED 'x  y'
+					choice (yes|no) "yes"
+					format NOTATION (gif) #REQUIRED
+					attr CDATA "redeclared">
+				<!NOTATION gif SYSTEM "viewgif.exe">
+				<!-- a comment with <!ENTITY ignored "ignored"> -->
+				<?pi <!ENTITY ignored "ignored">?>
+				<!ENTITY % pe "parameter">
+				<!ENTITY internal 'a &#x3C; &#60; &amp; &internal2; "quoted"'>
+				<!ENTITY external SYSTEM "world.txt">
+				<!ENTITY public PUBLIC "-//Example//ENTITIES//EN" "public.txt">
+				<!ENTITY unparsed SYSTEM "earth.gif" NDATA gif>
+				<!ENTITY internal "redeclared">
+			]>`,
+			Out: &dtd.DTD{
+				Name: "doc",
+				Entities: map[string]*dtd.Entity{
+					"internal": &dtd.Entity{Name: "internal", Value: `a < < &amp; &internal2; "quoted"`},
+					"external": &dtd.Entity{Name: "external", External: true, SystemID: "world.txt"},
+					"public":   &dtd.Entity{Name: "public", External: true, SystemID: "public.txt", PublicID: "-//Example//ENTITIES//EN"},
+					"unparsed": &dtd.Entity{Name: "unparsed", External: true, SystemID: "earth.gif", NData: "gif"},
+				},
+				Attlists: map[xml.Name][]*dtd.AttDef{
+					xml.Name{Local: "doc"}: []*dtd.AttDef{
+						&dtd.AttDef{Name: xml.Name{Local: "attr"}, Type: "CDATA", Default: "a > b", HasDefault: true},
+						&dtd.AttDef{Name: xml.Name{Local: "id"}, Type: "ID"},
+						&dtd.AttDef{Name: xml.Name{Space: "a", Local: "names"}, Type: "NMTOKENS", Default: "x  y", HasDefault: true},
+						&dtd.AttDef{Name: xml.Name{Local: "choice"}, Type: "ENUMERATION", Default: "yes", HasDefault: true},
+						&dtd.AttDef{Name: xml.Name{Local: "format"}, Type: "NOTATION"},
+					},
+				},
+			},
+		},
+		// Declarations after a parameter entity reference are not processed.
+		testCase{
+			In: `DOCTYPE doc [
+				<!ENTITY before "before">
+				%pe;
+				<!ENTITY after "after">
+				<!ATTLIST doc after CDATA "after">
+			]`,
+			Out: &dtd.DTD{
+				Name: "doc",
+				Entities: map[string]*dtd.Entity{
+					"before": &dtd.Entity{Name: "before", Value: "before"},
+				},
+				Attlists:  map[xml.Name][]*dtd.AttDef{},
+				Truncated: true,
+			},
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			out, err := dtd.Parse([]byte(strings.TrimSuffix(tt.In, ">")))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Out, out)
+		})
+	}
+}
+
+func TestParse_Error(t *testing.T) {
+	inputs := []string{
+		`ENTITY foo "bar"`,
+		`DOCTYPE`,
+		`DOCTYPE doc SYSTEM`,
+		`DOCTYPE doc [`,
+		`DOCTYPE doc [<!ENTITY foo "bar>]`,
+		`DOCTYPE doc [<!ENTITY foo "bar"]`,
+		`DOCTYPE doc [<!ENTITY foo "%bar;">]`,
+		`DOCTYPE doc [<!ENTITY foo "&#0;">]`,
+		`DOCTYPE doc [<!ENTITY foo "&bar">]`,
+		`DOCTYPE doc [<!ENTITY % foo SYSTEM "foo" NDATA bar>]`,
+		`DOCTYPE doc [<!BOGUS>]`,
+		`DOCTYPE doc [<!ATTLIST doc attr BOGUS #IMPLIED>]`,
+		`DOCTYPE doc [<!ATTLIST doc attr CDATA>]`,
+		`DOCTYPE doc [<!ATTLIST doc attr CDATA #FIXED>]`,
+		`DOCTYPE doc [<!ATTLIST doc attr NOTATION #IMPLIED>]`,
+		`DOCTYPE doc [<!-- unterminated ]`,
+		`DOCTYPE doc [] trailing`,
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			_, err := dtd.Parse([]byte(input))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpander(t *testing.T) {
+	d, err := dtd.Parse([]byte(`DOCTYPE doc [
+		<!ENTITY greeting "Hello">
+		<!ENTITY name "&#x57;orld">
+		<!ENTITY both "&greeting;, &name;">
+		<!ENTITY escaped "&#38;#38; &#38;lt;">
+		<!ENTITY markup "<b>bold</b>">
+		<!ENTITY indirectMarkup "&markup;">
+		<!ENTITY external SYSTEM "world.txt">
+		<!ENTITY indirectExternal "&external;">
+		<!ENTITY undeclared "&nope;">
+	]`))
+	assert.NoError(t, err)
+
+	x := d.NewExpander(0, nil)
+	assert.Equal(t, map[string]string{
+		"greeting": "Hello",
+		"name":     "World",
+		"both":     "Hello, World",
+		"escaped":  "& <",
+	}, expandAll(t, d, x))
+
+	for _, name := range []string{"markup", "indirectMarkup", "external", "indirectExternal", "undeclared", "nope"} {
+		_, err := x.Expand(name)
+		assert.Equal(t, dtd.ErrUnexpandable, err, name)
+	}
+}
+
+// expandAll returns the replacement text of each entity of d that x can
+// expand.
+func expandAll(t *testing.T, d *dtd.DTD, x *dtd.Expander) map[string]string {
+	entities := map[string]string{}
+	for name := range d.Entities {
+		value, err := x.Expand(name)
+		if err == dtd.ErrUnexpandable {
+			continue
+		}
+
+		assert.NoError(t, err)
+		entities[name] = value
+	}
+
+	return entities
+}
+
+func TestExpander_Recursive(t *testing.T) {
+	d, err := dtd.Parse([]byte(`DOCTYPE doc [
+		<!ENTITY a "&b;">
+		<!ENTITY b "&c;">
+		<!ENTITY c "&a;">
+	]`))
+	assert.NoError(t, err)
+
+	_, err = d.NewExpander(0, nil).Expand("a")
+	assert.EqualError(t, err, `dtd: entity "a" refers to itself`)
+}
+
+func TestExpander_Limit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`DOCTYPE lolz [<!ENTITY lol0 "lol">`)
+	for i := 1; i < 10; i++ {
+		fmt.Fprintf(&b, `<!ENTITY lol%d "%s">`, i, strings.Repeat(fmt.Sprintf("&lol%d;", i-1), 10))
+	}
+	b.WriteString(`]`)
+
+	d, err := dtd.Parse([]byte(b.String()))
+	assert.NoError(t, err)
+
+	_, err = d.NewExpander(1<<20, nil).Expand("lol9")
+	assert.Equal(t, dtd.ErrLimit, err)
+
+	// lol0 through lol3 expand to 3 + 30 + 300 + 3000 bytes. Entities that
+	// are not referred to are not expanded, and so don't count.
+	_, err = d.NewExpander(3333, nil).Expand("lol3")
+	assert.NoError(t, err)
+
+	_, err = d.NewExpander(3332, nil).Expand("lol3")
+	assert.Equal(t, dtd.ErrLimit, err)
+}
+
+func TestNormalizeDefaults(t *testing.T) {
+	d, err := dtd.Parse([]byte(`DOCTYPE doc [
+		<!ENTITY ent "entity">
+		<!ATTLIST doc
+			cdata CDATA "  a&#x20;&#9;b&#xA;	&ent; &lt;  "
+			names NMTOKENS "  a&#x20;&#9;b&#xA;	&ent; &lt;  ">
+	]`))
+	assert.NoError(t, err)
+
+	assert.NoError(t, d.NormalizeDefaults(d.NewExpander(0, nil)))
+
+	assert.Equal(t, "  a \tb\n entity <  ", d.Lookup(xml.Name{Local: "doc"}, xml.Name{Local: "cdata"}).Default)
+	assert.Equal(t, "a \tb\n entity <", d.Lookup(xml.Name{Local: "doc"}, xml.Name{Local: "names"}).Default)
+	assert.Nil(t, d.Lookup(xml.Name{Local: "doc"}, xml.Name{Local: "nope"}))
+}
+
+func TestNormalizeDefaults_Error(t *testing.T) {
+	inputs := []string{
+		`DOCTYPE doc [<!ATTLIST doc attr CDATA "&nope;">]`,
+		`DOCTYPE doc [<!ATTLIST doc attr CDATA "<">]`,
+		`DOCTYPE doc [<!ATTLIST doc attr CDATA "&#0;">]`,
+		`DOCTYPE doc [<!ATTLIST doc attr CDATA "&amp">]`,
+		`DOCTYPE doc [<!ENTITY ext SYSTEM "ext.txt"><!ATTLIST doc attr CDATA "&ext;">]`,
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			d, err := dtd.Parse([]byte(input))
+			assert.NoError(t, err)
+			resolve := func(e *dtd.Entity) ([]byte, error) {
+				return []byte("ext"), nil
+			}
+
+			assert.Error(t, d.NormalizeDefaults(d.NewExpander(0, resolve)))
+		})
+	}
+}
+
+func TestNormalizeValue(t *testing.T) {
+	testCases := map[string]string{
+		"":                  "",
+		"   ":               "",
+		"a":                 "a",
+		"  a  b   c  ":      "a b c",
+		" \t a \r\n  b \t ": "\t a \r\n b \t",
+	}
+
+	for in, out := range testCases {
+		assert.Equal(t, out, dtd.NormalizeValue(in), "%q", in)
+	}
+}
+
+func TestExpander_External(t *testing.T) {
+	d, err := dtd.Parse([]byte(`DOCTYPE doc [
+		<!ENTITY world SYSTEM "world.txt">
+		<!ENTITY declared SYSTEM "declared.txt">
+		<!ENTITY greeting "Hello, &world;!">
+		<!ENTITY unparsed SYSTEM "earth.gif" NDATA gif>
+	]`))
+	assert.NoError(t, err)
+
+	var resolved []string
+	x := d.NewExpander(0, func(e *dtd.Entity) ([]byte, error) {
+		resolved = append(resolved, e.SystemID)
+		if e.SystemID == "declared.txt" {
+			return []byte(`<?xml version="1.0" encoding="UTF-8"?>declared &#x26; decl`), nil
+		}
+
+		return []byte("world"), nil
+	})
+
+	// External entities are resolved when first expanded, and only then.
+	value, err := x.Expand("greeting")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello, world!", value)
+	assert.Equal(t, []string{"world.txt"}, resolved)
+
+	value, err = x.Expand("world")
+	assert.NoError(t, err)
+	assert.Equal(t, "world", value)
+	assert.Equal(t, []string{"world.txt"}, resolved)
+
+	value, err = x.Expand("declared")
+	assert.NoError(t, err)
+	assert.Equal(t, "declared & decl", value)
+	assert.Equal(t, []string{"world.txt", "declared.txt"}, resolved)
+
+	_, err = x.Expand("unparsed")
+	assert.Equal(t, dtd.ErrUnexpandable, err)
+
+	_, err = d.NewExpander(0, func(e *dtd.Entity) ([]byte, error) {
+		return nil, errDummy
+	}).Expand("world")
+
+	assert.Equal(t, errDummy, err)
+}
+
+var errDummy = errors.New("dummy error")
@@ -71,11 +71,30 @@ func (s SortAttr) Less(i, j int) bool {
 	// namespace URI is lexicographically least)."
 	//
 	// This just means: sort by Space first, break ties by Local.
-	spaceI, _ := s.Stack.Get(s.Attrs[i].Name.Space)
-	spaceJ, _ := s.Stack.Get(s.Attrs[j].Name.Space)
+	spaceI := s.namespaceURI(s.Attrs[i].Name.Space)
+	spaceJ := s.namespaceURI(s.Attrs[j].Name.Space)
 	if spaceI != spaceJ {
 		return spaceI < spaceJ
 	}
 
 	return s.Attrs[i].Name.Local < s.Attrs[j].Name.Local
 }
+
+// xmlNamespace is the namespace bound to the xml prefix, which is never
+// declared.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// namespaceURI returns the namespace URI of an attribute with the given prefix.
+// Unprefixed attributes have no namespace; in particular, the default
+// namespace does not apply to them.
+func (s SortAttr) namespaceURI(prefix string) string {
+	switch prefix {
+	case "":
+		return ""
+	case "xml":
+		return xmlNamespace
+	default:
+		uri, _ := s.Stack.Get(prefix)
+		return uri
+	}
+}
@@ -166,14 +166,46 @@ func TestSortAttr(t *testing.T) {
 				},
 			},
 		},
+		// Unprefixed attributes are in no namespace, even if there's a default
+		// namespace, and the xml prefix is bound without being declared.
+		testCase{
+			In: []xml.Attr{
+				xml.Attr{
+					Name:  xml.Name{Space: "xml", Local: "lang"},
+					Value: "en",
+				},
+				xml.Attr{
+					Name:  xml.Name{Space: "c", Local: "attr"},
+					Value: "prefixed",
+				},
+				xml.Attr{
+					Name:  xml.Name{Space: "", Local: "zzz"},
+					Value: "unprefixed",
+				},
+			},
+			Out: []xml.Attr{
+				xml.Attr{
+					Name:  xml.Name{Space: "", Local: "zzz"},
+					Value: "unprefixed",
+				},
+				xml.Attr{
+					Name:  xml.Name{Space: "c", Local: "attr"},
+					Value: "prefixed",
+				},
+				xml.Attr{
+					Name:  xml.Name{Space: "xml", Local: "lang"},
+					Value: "en",
+				},
+			},
+		},
 	}
 
 	var s stack.Stack
-	s.Push(map[string]string{
-		"":  "http://example.com",
-		"a": "http://www.w3.org",
-		"b": "http://www.ietf.org",
-	})
+	s.Push()
+	s.Bind("", "http://example.com")
+	s.Bind("a", "http://www.w3.org")
+	s.Bind("b", "http://www.ietf.org")
+	s.Bind("c", "http://a.example.com")
 
 	for i, tt := range testCases {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
@@ -184,6 +216,31 @@ func TestSortAttr(t *testing.T) {
 	}
 }
 
+func BenchmarkSortAttr(b *testing.B) {
+	var s stack.Stack
+	s.Push()
+	s.Bind("", "http://example.com")
+
+	var attrs []xml.Attr
+	for i := 0; i < 20; i++ {
+		prefix := "ns" + strconv.Itoa(i%5)
+		s.Bind(prefix, "http://example.com/"+prefix)
+
+		attrs = append(attrs,
+			xml.Attr{Name: xml.Name{Space: "", Local: "attr" + strconv.Itoa(20-i)}},
+			xml.Attr{Name: xml.Name{Space: prefix, Local: "attr" + strconv.Itoa(i)}},
+			xml.Attr{Name: xml.Name{Space: "xmlns", Local: prefix}},
+		)
+	}
+
+	in := make([]xml.Attr, len(attrs))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		copy(in, attrs)
+		sort.Sort(sortattr.SortAttr{Attrs: in, Stack: &s})
+	}
+}
+
 // <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
 // xmlns:b="http://www.ietf.org"
 // xmlns:a="http://www.w3.org"
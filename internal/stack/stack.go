@@ -1,47 +1,101 @@
 package stack
 
 // Stack is a stack of XML namespace declarations.
-type Stack []map[string]string
-
-// Push pushes a set of names and their corresponding URIs to the top of the
+//
+// Declarations are held in a single flat array, in the order they were made.
+// Each call to Push opens a new scope, and Pop discards every declaration made
+// since the matching Push. An index from each name to its innermost
+// declaration makes lookups constant-time, regardless of the depth of the
 // stack.
-func (s *Stack) Push(names map[string]string) {
-	*s = append(*s, names)
+//
+// The zero value is an empty stack, ready to use.
+type Stack struct {
+	bindings []binding      // all declarations currently in the stack
+	scopes   []int          // the start of each scope, as an index into bindings
+	index    map[string]int // the innermost declaration of each name
+}
+
+// binding is a single namespace declaration.
+type binding struct {
+	name string
+	uri  string
+	prev int // the declaration of name this one shadows, or -1 if none
+}
+
+// Push opens a new, empty scope at the top of the stack.
+func (s *Stack) Push() {
+	s.scopes = append(s.scopes, len(s.bindings))
+}
+
+// Bind declares a name and its corresponding URI in the scope at the top of
+// the stack. Bind must not be called on an empty stack.
+func (s *Stack) Bind(name, uri string) {
+	if s.index == nil {
+		s.index = map[string]int{}
+	}
+
+	prev, ok := s.index[name]
+	if !ok {
+		prev = -1
+	}
+
+	s.index[name] = len(s.bindings)
+	s.bindings = append(s.bindings, binding{name: name, uri: uri, prev: prev})
 }
 
-// Get fetches the URI for a name (or the empth string if not found) and whether
+// Get fetches the URI for a name (or the empty string if not found) and whether
 // the name was found at all. Definitions closer to the top of the stack take
-// predence over values further from the top.
+// precedence over values further from the top.
 func (s *Stack) Get(name string) (string, bool) {
-	for i := len(*s) - 1; i >= 0; i-- {
-		if uri, ok := (*s)[i][name]; ok {
-			return uri, true
-		}
+	if i, ok := s.index[name]; ok {
+		return s.bindings[i].uri, true
 	}
 
 	return "", false
 }
 
-// Pop pops the top of the name stack.
+// Pop pops the top scope of the stack, discarding the names declared in it.
 func (s *Stack) Pop() {
-	(*s) = (*s)[:len(*s)-1]
+	start := s.scopes[len(s.scopes)-1]
+	s.scopes = s.scopes[:len(s.scopes)-1]
+
+	// Undo the declarations in reverse order, so that a name declared twice in
+	// the same scope is restored to its value from before the scope.
+	for i := len(s.bindings) - 1; i >= start; i-- {
+		b := s.bindings[i]
+		if b.prev == -1 {
+			delete(s.index, b.name)
+		} else {
+			s.index[b.name] = b.prev
+		}
+	}
+
+	s.bindings = s.bindings[:start]
+}
+
+// Reset empties the stack, retaining any memory it has already allocated.
+func (s *Stack) Reset() {
+	for name := range s.index {
+		delete(s.index, name)
+	}
+
+	s.bindings = s.bindings[:0]
+	s.scopes = s.scopes[:0]
 }
 
 // Len returns depth of the stack.
 func (s *Stack) Len() int {
-	return len(*s)
+	return len(s.scopes)
 }
 
-// GetAll returns all names in the stack, and their current values. Definitions
-// closer to the top of the stack take predence over values further from the
-// top.
-func (s *Stack) GetAll() map[string]string {
-	out := map[string]string{}
-	for _, names := range *s {
-		for name, uri := range names {
-			out[name] = uri
+// Each calls f with every name in the stack, and its current value. Each name
+// is visited once, in the order in which the names were first declared.
+func (s *Stack) Each(f func(name, uri string)) {
+	for _, b := range s.bindings {
+		// Visit each name at its outermost declaration, but with the value of its
+		// innermost one.
+		if b.prev == -1 {
+			f(b.name, s.bindings[s.index[b.name]].uri)
 		}
 	}
-
-	return out
 }
@@ -1,6 +1,7 @@
 package stack_test
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,13 +13,12 @@ func TestStack(t *testing.T) {
 
 	assert.Equal(t, 0, s.Len())
 	assertGet(t, &s, "unknown", "", false)
-	assert.Equal(t, map[string]string{}, s.GetAll())
+	assert.Equal(t, map[string]string{}, getAll(&s))
 
-	s.Push(map[string]string{
-		"foo": "http://example.com/foo",
-		"bar": "http://example.com/bar",
-		"baz": "http://example.com/baz",
-	})
+	s.Push()
+	s.Bind("foo", "http://example.com/foo")
+	s.Bind("bar", "http://example.com/bar")
+	s.Bind("baz", "http://example.com/baz")
 
 	assert.Equal(t, 1, s.Len())
 	assertGet(t, &s, "foo", "http://example.com/foo", true)
@@ -28,12 +28,11 @@ func TestStack(t *testing.T) {
 		"foo": "http://example.com/foo",
 		"bar": "http://example.com/bar",
 		"baz": "http://example.com/baz",
-	}, s.GetAll())
+	}, getAll(&s))
 
-	s.Push(map[string]string{
-		"foo": "http://example.com/foo/new",
-		"bar": "http://example.com/bar",
-	})
+	s.Push()
+	s.Bind("foo", "http://example.com/foo/new")
+	s.Bind("bar", "http://example.com/bar")
 
 	assert.Equal(t, 2, s.Len())
 	assertGet(t, &s, "foo", "http://example.com/foo/new", true)
@@ -43,7 +42,7 @@ func TestStack(t *testing.T) {
 		"foo": "http://example.com/foo/new",
 		"bar": "http://example.com/bar",
 		"baz": "http://example.com/baz",
-	}, s.GetAll())
+	}, getAll(&s))
 
 	s.Pop()
 
@@ -55,12 +54,77 @@ func TestStack(t *testing.T) {
 		"foo": "http://example.com/foo",
 		"bar": "http://example.com/bar",
 		"baz": "http://example.com/baz",
-	}, s.GetAll())
+	}, getAll(&s))
+
+	s.Pop()
+
+	assert.Equal(t, 0, s.Len())
+	assertGet(t, &s, "foo", "", false)
+	assert.Equal(t, map[string]string{}, getAll(&s))
+}
 
+func TestStack_EmptyScopes(t *testing.T) {
+	var s stack.Stack
+
+	s.Push()
+	s.Bind("foo", "http://example.com/foo")
+	s.Push()
+	s.Push()
+	s.Bind("foo", "http://example.com/foo/new")
+	s.Push()
+
+	assert.Equal(t, 4, s.Len())
+	assertGet(t, &s, "foo", "http://example.com/foo/new", true)
+
+	s.Pop()
+	s.Pop()
+
+	assert.Equal(t, 2, s.Len())
+	assertGet(t, &s, "foo", "http://example.com/foo", true)
+
+	s.Pop()
 	s.Pop()
 
 	assert.Equal(t, 0, s.Len())
-	assert.Equal(t, map[string]string{}, s.GetAll())
+	assertGet(t, &s, "foo", "", false)
+}
+
+func TestStack_Redeclaration(t *testing.T) {
+	var s stack.Stack
+
+	s.Push()
+	s.Bind("foo", "http://example.com/1")
+	s.Push()
+	s.Bind("foo", "http://example.com/2")
+	s.Bind("foo", "http://example.com/3")
+
+	assertGet(t, &s, "foo", "http://example.com/3", true)
+	assert.Equal(t, map[string]string{"foo": "http://example.com/3"}, getAll(&s))
+
+	s.Pop()
+
+	assertGet(t, &s, "foo", "http://example.com/1", true)
+	assert.Equal(t, map[string]string{"foo": "http://example.com/1"}, getAll(&s))
+}
+
+func TestStack_EachOrder(t *testing.T) {
+	var s stack.Stack
+
+	s.Push()
+	s.Bind("b", "http://example.com/b")
+	s.Bind("a", "http://example.com/a")
+	s.Push()
+	s.Bind("c", "http://example.com/c")
+	s.Bind("b", "http://example.com/b/new")
+
+	var names, uris []string
+	s.Each(func(name, uri string) {
+		names = append(names, name)
+		uris = append(uris, uri)
+	})
+
+	assert.Equal(t, []string{"b", "a", "c"}, names)
+	assert.Equal(t, []string{"http://example.com/b/new", "http://example.com/a", "http://example.com/c"}, uris)
 }
 
 func assertGet(t *testing.T, s *stack.Stack, k, v string, ok bool) {
@@ -68,3 +132,39 @@ func assertGet(t *testing.T, s *stack.Stack, k, v string, ok bool) {
 	assert.Equal(t, v, actualV)
 	assert.Equal(t, ok, actualOk)
 }
+
+func getAll(s *stack.Stack) map[string]string {
+	out := map[string]string{}
+	s.Each(func(name, uri string) {
+		out[name] = uri
+	})
+
+	return out
+}
+
+func BenchmarkStack(b *testing.B) {
+	for _, depth := range []int{10, 1000} {
+		b.Run(strconv.Itoa(depth), func(b *testing.B) {
+			names := make([]string, depth)
+			for i := range names {
+				names[i] = "ns" + strconv.Itoa(i)
+			}
+
+			b.ReportAllocs()
+			var s stack.Stack
+			for i := 0; i < b.N; i++ {
+				// Simulate a document depth elements deep, where each element
+				// declares a name and looks up the outermost one.
+				for _, name := range names {
+					s.Push()
+					s.Bind(name, "http://example.com")
+					s.Get(names[0])
+				}
+
+				for range names {
+					s.Pop()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+// Package xmlchar holds the character classes of the XML grammar, shared by
+// the encoder, the tokenizer and the DTD parser.
+//
+// https://www.w3.org/TR/xml/#charsets
+package xmlchar
+
+import "unicode/utf8"
+
+// PredefinedEntities are the entities every XML processor recognizes without
+// a declaration, and their replacement text.
+var PredefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// IsChar returns whether r matches the Char production of XML 1.0.
+//
+// https://www.w3.org/TR/xml/#NT-Char
+func IsChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// IsChar11 returns whether r matches the Char production of XML 1.1, which
+// adds the control characters other than NUL to that of XML 1.0.
+//
+// https://www.w3.org/TR/xml11/#NT-Char
+func IsChar11(r rune) bool {
+	return r >= 0x1 && r <= 0x1F || IsChar(r)
+}
+
+// IsRestrictedChar returns whether r matches the RestrictedChar production of
+// XML 1.1: the characters that may only be written as character references.
+//
+// https://www.w3.org/TR/xml11/#NT-RestrictedChar
+func IsRestrictedChar(r rune) bool {
+	return r >= 0x1 && r <= 0x8 || r == 0xB || r == 0xC || r >= 0xE && r <= 0x1F ||
+		r >= 0x7F && r <= 0x84 || r >= 0x86 && r <= 0x9F
+}
+
+// IsSpace returns whether r matches the S production of XML 1.0.
+func IsSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// IsName returns whether s matches the Name production of XML 1.0. Invalid
+// UTF-8 never does.
+//
+// https://www.w3.org/TR/xml/#NT-Name
+func IsName(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+
+	for i, r := range s {
+		if !IsNameRune(r, i == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// IsNameRune returns whether r matches the NameStartChar production of XML
+// 1.0, if first, or else the NameChar production.
+//
+// https://www.w3.org/TR/xml/#NT-NameStartChar
+func IsNameRune(r rune, first bool) bool {
+	switch {
+	case r == ':', r >= 'A' && r <= 'Z', r == '_', r >= 'a' && r <= 'z',
+		r >= 0xC0 && r <= 0xD6, r >= 0xD8 && r <= 0xF6, r >= 0xF8 && r <= 0x2FF,
+		r >= 0x370 && r <= 0x37D, r >= 0x37F && r <= 0x1FFF, r >= 0x200C && r <= 0x200D,
+		r >= 0x2070 && r <= 0x218F, r >= 0x2C00 && r <= 0x2FEF, r >= 0x3001 && r <= 0xD7FF,
+		r >= 0xF900 && r <= 0xFDCF, r >= 0xFDF0 && r <= 0xFFFD, r >= 0x10000 && r <= 0xEFFFF:
+		return true
+	case r == '-', r == '.', r >= '0' && r <= '9', r == 0xB7,
+		r >= 0x300 && r <= 0x36F, r >= 0x203F && r <= 0x2040:
+		return !first
+	default:
+		return false
+	}
+}
@@ -0,0 +1,46 @@
+package xmlchar_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n/internal/xmlchar"
+)
+
+func TestIsChar(t *testing.T) {
+	for _, r := range []rune{'\t', '\n', '\r', ' ', 'a', 0xD7FF, 0xE000, 0xFFFD, 0x10000, 0x10FFFF} {
+		assert.True(t, xmlchar.IsChar(r), "%U", r)
+	}
+
+	for _, r := range []rune{0x0, 0x1, 0x8, 0xB, 0x1F, 0xD800, 0xDFFF, 0xFFFE, 0xFFFF, 0x110000} {
+		assert.False(t, xmlchar.IsChar(r), "%U", r)
+	}
+}
+
+func TestIsChar11(t *testing.T) {
+	assert.False(t, xmlchar.IsChar11(0x0))
+	assert.True(t, xmlchar.IsChar11(0x1))
+	assert.True(t, xmlchar.IsChar11(0x1F))
+	assert.True(t, xmlchar.IsChar11(0x85))
+	assert.False(t, xmlchar.IsChar11(0xFFFE))
+}
+
+func TestIsRestrictedChar(t *testing.T) {
+	for _, r := range []rune{0x1, 0x8, 0xB, 0xC, 0xE, 0x1F, 0x7F, 0x84, 0x86, 0x9F} {
+		assert.True(t, xmlchar.IsRestrictedChar(r), "%U", r)
+	}
+
+	for _, r := range []rune{0x0, '\t', '\n', '\r', ' ', 0x85, 0xA0} {
+		assert.False(t, xmlchar.IsRestrictedChar(r), "%U", r)
+	}
+}
+
+func TestIsName(t *testing.T) {
+	for _, s := range []string{"a", "_a", ":a", "a-b.c", "a1", "\u00e9t\u00e9", "a\u00b7b"} {
+		assert.True(t, xmlchar.IsName(s), "%q", s)
+	}
+
+	for _, s := range []string{"", "1a", "-a", ".a", "a b", "a\x01", "\u00b7a", "a\u00d7b", "a?", "a\xff"} {
+		assert.False(t, xmlchar.IsName(s), "%q", s)
+	}
+}
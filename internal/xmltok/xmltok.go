@@ -0,0 +1,1121 @@
+// Package xmltok reads the tokens of XML 1.0 documents, and optionally of XML
+// 1.1 documents.
+//
+// Unlike xml.Decoder, a Tokenizer enforces the well-formedness constraints of
+// the XML spec, normalizes line endings and attribute values as the spec
+// requires, and expands references to the entities declared in a document's
+// internal subset, even when their replacement text contains markup. It also
+// reports details that an xml.Token cannot hold, such as how each attribute
+// value was quoted, and where CDATA sections and references began and ended;
+// see Info.
+//
+// https://www.w3.org/TR/xml/
+package xmltok
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ucarion/c14n/internal/dtd"
+	"github.com/ucarion/c14n/internal/xmlchar"
+)
+
+// DefaultMaxEntityExpansion is the value of Tokenizer.MaxEntityExpansion used
+// when none is given.
+const DefaultMaxEntityExpansion = 1 << 20
+
+// ErrLimit is returned once expanding entity references has produced more
+// replacement text than a Tokenizer allows.
+var ErrLimit = errors.New("xmltok: entity expansion limit exceeded")
+
+// errEntityEnd signals that the replacement text of the innermost entity being
+// expanded has been consumed.
+var errEntityEnd = errors.New("xmltok: end of entity")
+
+// Tokenizer reads the tokens of an XML 1.0 document. Its tokens have the same
+// form as those of xml.Decoder's RawToken method: names hold prefixes, not
+// namespace URIs, and an empty-element tag is reported as a StartElement
+// followed by an EndElement.
+//
+// Unlike xml.Decoder, character data is split into several CharData tokens
+// wherever a CDATA section or a reference begins or ends.
+type Tokenizer struct {
+	// CharsetReader, if non-nil, is used as xml.Decoder uses it: to obtain a
+	// reader of UTF-8 from a document that declares another encoding. If nil,
+	// such documents are an error.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// Resolve, if non-nil, obtains the content of external parsed entities.
+	// If nil, a reference to an external entity is an error.
+	Resolve func(publicID, systemID string) ([]byte, error)
+
+	// MaxEntityExpansion is the maximum total size, in bytes, of the
+	// replacement text that expanding entity references may produce. Zero
+	// means DefaultMaxEntityExpansion.
+	MaxEntityExpansion int
+
+	// XML11 accepts documents that declare version 1.1, and reads them as XML
+	// 1.1 requires: NEL and LS characters, and CR followed by NEL, are line
+	// endings too, and character references may denote the control
+	// characters that XML 1.0 forbids. If false, such documents are an error.
+	//
+	// https://www.w3.org/TR/xml11/
+	XML11 bool
+
+	r         *bufio.Reader
+	line, col int // the position of the next character of the document
+
+	started  bool              // whether the start of the document has been read
+	v11      bool              // whether the document is XML 1.1
+	names    []xml.Name        // the names of the open elements
+	sawRoot  bool              // whether the root element has started
+	dtd      *dtd.DTD          // the document type declaration, if any
+	frames   []*frame          // the entities being expanded, innermost last
+	external map[string]string // the replacement text of each external entity resolved so far
+	expanded int               // the total size of the replacement text pushed so far
+	pending  xml.Token         // the EndElement of an empty-element tag
+	info     Info
+	err      error
+}
+
+// frame is the replacement text of an entity being expanded.
+type frame struct {
+	name  string
+	text  string
+	pos   int
+	depth int // the number of open elements when the reference was made
+}
+
+// Info describes how the most recently returned token was written.
+type Info struct {
+	// Line and Column are the position in the document at which the token
+	// starts. A token within the replacement text of an entity has the
+	// position of the end of the outermost reference to it.
+	Line, Column int
+
+	// Entity is the name of the innermost general entity whose replacement
+	// text holds the token, or empty if the token is in the document itself.
+	Entity string
+
+	// Reference is, for a CharData token that came from a character reference
+	// or a reference to a predefined entity, the reference as written without
+	// its leading '&' and trailing ';', such as "#x20" or "amp".
+	Reference string
+
+	// CDATA is true for a CharData token that came from a CDATA section.
+	CDATA bool
+
+	// Quotes is, for a StartElement token, the quotation mark each attribute
+	// value was written with: '"' or '\''.
+	Quotes []byte
+}
+
+// New returns a Tokenizer that reads a document from r.
+func New(r io.Reader) *Tokenizer {
+	return &Tokenizer{r: bufio.NewReader(r), line: 1, col: 1}
+}
+
+// Info describes the most recently returned token. Its fields must not be
+// modified.
+func (t *Tokenizer) Info() Info {
+	return t.info
+}
+
+// RawToken returns the next token of the document, or io.EOF once the
+// document has ended. Malformed documents produce an *xml.SyntaxError. Once
+// RawToken returns an error, it returns the same error on every later call.
+func (t *Tokenizer) RawToken() (xml.Token, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+
+	tok, err := t.token()
+	if err != nil {
+		t.err = err
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+func (t *Tokenizer) token() (xml.Token, error) {
+	if t.pending != nil {
+		tok := t.pending
+		t.pending = nil
+		t.info.Quotes = nil
+		return tok, nil
+	}
+
+	if !t.started {
+		t.started = true
+		if t.hasPrefix("\uFEFF") {
+			t.r.Discard(3)
+		}
+
+		t.info = Info{Line: t.line, Column: t.col}
+		if t.hasPrefix("<?xml") && t.hasSpaceAt(5) {
+			return t.xmlDecl()
+		}
+	}
+
+	for len(t.frames) > 0 {
+		f := t.frames[len(t.frames)-1]
+		if f.pos < len(f.text) {
+			break
+		}
+
+		if len(t.names) != f.depth {
+			return nil, t.syntaxError("element in entity %q is not closed within it", f.name)
+		}
+
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+
+	t.info = Info{Line: t.line, Column: t.col, Entity: t.entity()}
+
+	switch {
+	case t.hasPrefix("<?"):
+		return t.procInst()
+	case t.hasPrefix("<!--"):
+		return t.comment()
+	case t.hasPrefix("<![CDATA["):
+		return t.cdata()
+	case t.hasPrefix("<!DOCTYPE"):
+		return t.doctype()
+	case t.hasPrefix("<!"):
+		return nil, t.syntaxError("unexpected <!")
+	case t.hasPrefix("</"):
+		return t.endElement()
+	case t.hasPrefix("<"):
+		return t.startElement()
+	case t.hasPrefix("&"):
+		return t.reference()
+	}
+
+	if _, err := t.peekByte(); err != nil {
+		if err != io.EOF {
+			return nil, err
+		}
+
+		if len(t.names) > 0 {
+			return nil, t.syntaxError("unexpected EOF")
+		}
+
+		return nil, io.EOF
+	}
+
+	return t.text()
+}
+
+// xmlDecl reads the XML declaration that may begin a document, and switches
+// to the encoding it declares.
+func (t *Tokenizer) xmlDecl() (xml.Token, error) {
+	t.skip(len("<?xml"))
+	t.space()
+
+	inst, err := t.until("?>")
+	if err != nil {
+		return nil, err
+	}
+
+	version, encoding, err := parseXMLDecl(inst)
+	if err != nil {
+		return nil, t.syntaxError("%v", err)
+	}
+
+	if version == "1.1" && t.XML11 {
+		t.v11 = true
+	} else if version != "1.0" {
+		return nil, t.syntaxError("unsupported version %q; only version 1.0 is supported", version)
+	}
+
+	if encoding != "" && !strings.EqualFold(encoding, "UTF-8") {
+		if t.CharsetReader == nil {
+			return nil, t.syntaxError("unsupported encoding %q", encoding)
+		}
+
+		r, err := t.CharsetReader(encoding, t.r)
+		if err != nil {
+			return nil, fmt.Errorf("xmltok: opening charset %q: %v", encoding, err)
+		}
+
+		if r == nil {
+			return nil, fmt.Errorf("xmltok: CharsetReader returned a nil reader for charset %q", encoding)
+		}
+
+		t.r = bufio.NewReader(r)
+	}
+
+	return xml.ProcInst{Target: "xml", Inst: []byte(inst)}, nil
+}
+
+// parseXMLDecl parses the pseudo-attributes of an XML declaration.
+//
+// https://www.w3.org/TR/xml/#NT-XMLDecl
+func parseXMLDecl(inst string) (version, encoding string, err error) {
+	names := []string{"version", "encoding", "standalone"}
+	values := map[string]string{}
+
+	s := inst
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return "", "", fmt.Errorf("malformed XML declaration %q", inst)
+		}
+
+		name := strings.TrimRight(s[:eq], " \t\n")
+		for len(names) > 0 && names[0] != name {
+			names = names[1:]
+		}
+
+		if len(names) == 0 {
+			return "", "", fmt.Errorf("unexpected %q in XML declaration", name)
+		}
+
+		names = names[1:]
+
+		s = strings.TrimLeft(s[eq+1:], " \t\n")
+		if s == "" || (s[0] != '"' && s[0] != '\'') {
+			return "", "", fmt.Errorf("malformed XML declaration %q", inst)
+		}
+
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", "", fmt.Errorf("malformed XML declaration %q", inst)
+		}
+
+		values[name] = s[1 : end+1]
+		rest := strings.TrimLeft(s[end+2:], " \t\n")
+		if rest != "" && rest == s[end+2:] {
+			return "", "", fmt.Errorf("malformed XML declaration %q", inst)
+		}
+
+		s = rest
+	}
+
+	version, ok := values["version"]
+	if !ok {
+		return "", "", errors.New("XML declaration lacks a version")
+	}
+
+	if standalone, ok := values["standalone"]; ok && standalone != "yes" && standalone != "no" {
+		return "", "", fmt.Errorf("invalid standalone %q in XML declaration", standalone)
+	}
+
+	return version, values["encoding"], nil
+}
+
+// procInst reads a processing instruction.
+func (t *Tokenizer) procInst() (xml.Token, error) {
+	t.skip(len("<?"))
+
+	target, err := t.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(target, "xml") {
+		return nil, t.syntaxError("processing instruction target %q is reserved", target)
+	}
+
+	if !t.space() && !t.hasPrefix("?>") {
+		return nil, t.syntaxError("expected whitespace after processing instruction target")
+	}
+
+	inst, err := t.until("?>")
+	if err != nil {
+		return nil, err
+	}
+
+	return xml.ProcInst{Target: target, Inst: []byte(inst)}, nil
+}
+
+// comment reads a comment.
+func (t *Tokenizer) comment() (xml.Token, error) {
+	t.skip(len("<!--"))
+
+	var b []byte
+	for !t.hasPrefix("--") {
+		r, err := t.readRune()
+		if err != nil {
+			return nil, t.unexpected(err)
+		}
+
+		b = utf8.AppendRune(b, r)
+	}
+
+	t.skip(len("--"))
+	if !t.hasPrefix(">") {
+		return nil, t.syntaxError("'--' in comment")
+	}
+
+	t.skip(len(">"))
+	return xml.Comment(b), nil
+}
+
+// cdata reads a CDATA section.
+func (t *Tokenizer) cdata() (xml.Token, error) {
+	if len(t.names) == 0 {
+		return nil, t.syntaxError("CDATA section outside the root element")
+	}
+
+	t.skip(len("<![CDATA["))
+
+	s, err := t.until("]]>")
+	if err != nil {
+		return nil, err
+	}
+
+	t.info.CDATA = true
+	return xml.CharData(s), nil
+}
+
+// doctype reads a document type declaration, and processes its internal
+// subset.
+func (t *Tokenizer) doctype() (xml.Token, error) {
+	if t.sawRoot || t.dtd != nil {
+		return nil, t.syntaxError("unexpected document type declaration")
+	}
+
+	t.skip(len("<!"))
+
+	// Find the '>' that ends the declaration, skipping over any in literals,
+	// comments and processing instructions.
+	var b []byte
+	var quote rune
+	subset := false
+scan:
+	for {
+		if quote == 0 && subset {
+			for _, delims := range [][2]string{{"<!--", "-->"}, {"<?", "?>"}} {
+				if t.hasPrefix(delims[0]) {
+					t.skip(len(delims[0]))
+					s, err := t.until(delims[1])
+					if err != nil {
+						return nil, err
+					}
+
+					b = append(b, delims[0]+s+delims[1]...)
+					continue scan
+				}
+			}
+		}
+
+		r, err := t.readRune()
+		if err != nil {
+			return nil, t.unexpected(err)
+		}
+
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[':
+			subset = true
+		case r == ']':
+			subset = false
+		case r == '>' && !subset:
+			d, err := dtd.Parse(b)
+			if err != nil {
+				return nil, t.syntaxError("%v", err)
+			}
+
+			t.dtd = d
+			return xml.Directive(b), nil
+		}
+
+		b = utf8.AppendRune(b, r)
+	}
+}
+
+// startElement reads a start tag or an empty-element tag.
+func (t *Tokenizer) startElement() (xml.Token, error) {
+	if len(t.names) == 0 && t.sawRoot {
+		return nil, t.syntaxError("content after the root element")
+	}
+
+	t.skip(len("<"))
+
+	name, err := t.qualifiedName()
+	if err != nil {
+		return nil, err
+	}
+
+	var attrs []xml.Attr
+	var quotes []byte
+	empty := false
+	for {
+		hadSpace := t.space()
+		if t.hasPrefix("/>") {
+			t.skip(len("/>"))
+			empty = true
+			break
+		}
+
+		if t.hasPrefix(">") {
+			t.skip(len(">"))
+			break
+		}
+
+		if _, err := t.peekByte(); err != nil {
+			return nil, t.unexpected(err)
+		}
+
+		if !hadSpace {
+			return nil, t.syntaxError("expected whitespace before attribute")
+		}
+
+		attrName, err := t.qualifiedName()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Name == attrName {
+				return nil, t.syntaxError("attribute %q appears more than once", qualify(attrName))
+			}
+		}
+
+		t.space()
+		if !t.hasPrefix("=") {
+			return nil, t.syntaxError("expected '=' after attribute %q", qualify(attrName))
+		}
+
+		t.skip(len("="))
+		t.space()
+
+		quote, err := t.peekByte()
+		if err != nil {
+			return nil, t.unexpected(err)
+		}
+
+		if quote != '"' && quote != '\'' {
+			return nil, t.syntaxError("unquoted value for attribute %q", qualify(attrName))
+		}
+
+		t.skip(1)
+		value, err := t.attrValue(quote)
+		if err != nil {
+			return nil, err
+		}
+
+		attrs = append(attrs, xml.Attr{Name: attrName, Value: value})
+		quotes = append(quotes, quote)
+	}
+
+	t.sawRoot = true
+	t.info.Quotes = quotes
+
+	if empty {
+		t.pending = xml.EndElement{Name: name}
+	} else {
+		t.names = append(t.names, name)
+	}
+
+	return xml.StartElement{Name: name, Attr: attrs}, nil
+}
+
+// attrValue reads an attribute value through its closing quote, and
+// normalizes it.
+//
+// https://www.w3.org/TR/xml/#AVNormalize
+func (t *Tokenizer) attrValue(quote byte) (string, error) {
+	var b strings.Builder
+	for {
+		r, err := t.readRune()
+		if err != nil {
+			return "", t.unexpected(err)
+		}
+
+		switch {
+		case r == rune(quote):
+			return b.String(), nil
+		case r == '<':
+			return "", t.syntaxError("'<' in attribute value")
+		case r == '&':
+			ref, err := t.ref()
+			if err != nil {
+				return "", err
+			}
+
+			if err := t.attrReference(&b, ref, nil); err != nil {
+				return "", err
+			}
+		case xmlchar.IsSpace(r):
+			b.WriteByte(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+}
+
+// attrReference writes the normalized replacement of a reference within an
+// attribute value to b. Stack holds the entities being expanded.
+func (t *Tokenizer) attrReference(b *strings.Builder, ref string, stack []string) error {
+	if strings.HasPrefix(ref, "#") {
+		r, err := t.charRef(ref)
+		if err != nil {
+			return err
+		}
+
+		b.WriteRune(r)
+		return nil
+	}
+
+	if s, ok := xmlchar.PredefinedEntities[ref]; ok {
+		b.WriteString(s)
+		return nil
+	}
+
+	entity, err := t.lookup(ref, stack)
+	if err != nil {
+		return err
+	}
+
+	if entity.External {
+		return t.syntaxError("reference to external entity &%s; in attribute value", ref)
+	}
+
+	if err := t.expand(len(entity.Value)); err != nil {
+		return err
+	}
+
+	stack = append(stack, ref)
+	for s := entity.Value; s != ""; {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+
+		switch {
+		case r == '<':
+			return t.syntaxError("'<' in replacement text of entity %q, referenced in attribute value", ref)
+		case r == '&':
+			end := strings.IndexByte(s, ';')
+			if end < 0 {
+				return t.syntaxError("unterminated reference in entity %q", ref)
+			}
+
+			if err := t.attrReference(b, s[:end], stack); err != nil {
+				return err
+			}
+
+			s = s[end+1:]
+		case xmlchar.IsSpace(r):
+			b.WriteByte(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return nil
+}
+
+// endElement reads an end tag.
+func (t *Tokenizer) endElement() (xml.Token, error) {
+	t.skip(len("</"))
+
+	name, err := t.qualifiedName()
+	if err != nil {
+		return nil, err
+	}
+
+	t.space()
+	if !t.hasPrefix(">") {
+		return nil, t.syntaxError("expected '>' after end tag name %q", qualify(name))
+	}
+
+	t.skip(len(">"))
+
+	if len(t.names) == 0 {
+		return nil, t.syntaxError("unexpected end element </%s>", qualify(name))
+	}
+
+	if open := t.names[len(t.names)-1]; open != name {
+		return nil, t.syntaxError("element <%s> closed by </%s>", qualify(open), qualify(name))
+	}
+
+	if n := len(t.frames); n > 0 && t.frames[n-1].depth == len(t.names) {
+		return nil, t.syntaxError("end element </%s> in entity %q closes an element opened outside it", qualify(name), t.frames[n-1].name)
+	}
+
+	t.names = t.names[:len(t.names)-1]
+	return xml.EndElement{Name: name}, nil
+}
+
+// reference reads a character or entity reference in content. A character
+// reference, or a reference to a predefined entity, produces a CharData token.
+// A reference to a declared entity starts the expansion of its replacement
+// text, and produces its first token.
+func (t *Tokenizer) reference() (xml.Token, error) {
+	t.skip(len("&"))
+
+	ref, err := t.ref()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.names) == 0 {
+		return nil, t.syntaxError("reference &%s; outside the root element", ref)
+	}
+
+	if strings.HasPrefix(ref, "#") {
+		r, err := t.charRef(ref)
+		if err != nil {
+			return nil, err
+		}
+
+		t.info.Reference = ref
+		return xml.CharData(string(r)), nil
+	}
+
+	if s, ok := xmlchar.PredefinedEntities[ref]; ok {
+		t.info.Reference = ref
+		return xml.CharData(s), nil
+	}
+
+	entity, err := t.lookup(ref, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	text := entity.Value
+	if entity.External {
+		if text, err = t.resolve(ref, entity); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := t.expand(len(text)); err != nil {
+		return nil, err
+	}
+
+	t.frames = append(t.frames, &frame{name: ref, text: text, depth: len(t.names)})
+	return t.token()
+}
+
+// resolve returns the replacement text of the external entity name, calling
+// Resolve only the first time the entity is referred to.
+func (t *Tokenizer) resolve(name string, entity *dtd.Entity) (string, error) {
+	if text, ok := t.external[name]; ok {
+		return text, nil
+	}
+
+	if t.Resolve == nil {
+		return "", t.syntaxError("cannot resolve external entity &%s;", name)
+	}
+
+	content, err := t.Resolve(entity.PublicID, entity.SystemID)
+	if err != nil {
+		return "", err
+	}
+
+	text, err := t.externalText(name, content)
+	if err != nil {
+		return "", err
+	}
+
+	if t.external == nil {
+		t.external = map[string]string{}
+	}
+
+	t.external[name] = text
+	return text, nil
+}
+
+// lookup returns the declaration of the parsed entity name. Stack holds the
+// names of entities being expanded in attribute values, in addition to those
+// being expanded in content.
+func (t *Tokenizer) lookup(name string, stack []string) (*dtd.Entity, error) {
+	if !xmlchar.IsName(name) {
+		return nil, t.syntaxError("invalid reference &%s;", name)
+	}
+
+	var entity *dtd.Entity
+	if t.dtd != nil {
+		entity = t.dtd.Entities[name]
+	}
+
+	if entity == nil {
+		return nil, t.syntaxError("undeclared entity &%s;", name)
+	}
+
+	if entity.NData != "" {
+		return nil, t.syntaxError("reference to unparsed entity &%s;", name)
+	}
+
+	for _, f := range t.frames {
+		if f.name == name {
+			return nil, t.syntaxError("entity %q refers to itself", name)
+		}
+	}
+
+	for _, s := range stack {
+		if s == name {
+			return nil, t.syntaxError("entity %q refers to itself", name)
+		}
+	}
+
+	return entity, nil
+}
+
+// externalText returns the replacement text of an external parsed entity,
+// given its content.
+func (t *Tokenizer) externalText(name string, content []byte) (string, error) {
+	if !utf8.Valid(content) {
+		return "", t.syntaxError("invalid UTF-8 in external entity %q", name)
+	}
+
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	text = dtd.StripTextDecl(text)
+
+	for _, r := range text {
+		if !xmlchar.IsChar(r) {
+			return "", t.syntaxError("illegal character U+%04X in external entity %q", r, name)
+		}
+	}
+
+	return text, nil
+}
+
+// expand accounts for n more bytes of replacement text.
+func (t *Tokenizer) expand(n int) error {
+	limit := t.MaxEntityExpansion
+	if limit <= 0 {
+		limit = DefaultMaxEntityExpansion
+	}
+
+	t.expanded += n
+	if t.expanded > limit {
+		return ErrLimit
+	}
+
+	return nil
+}
+
+// charRef parses a character reference, without its leading '&' and trailing
+// ';'.
+func (t *Tokenizer) charRef(ref string) (rune, error) {
+	digits, base := ref[1:], 10
+	if strings.HasPrefix(digits, "x") {
+		digits, base = digits[1:], 16
+	}
+
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || digits == "" || digits[0] == '+' || !(xmlchar.IsChar(rune(n)) || t.v11 && xmlchar.IsChar11(rune(n))) {
+		return 0, t.syntaxError("invalid character reference &%s;", ref)
+	}
+
+	return rune(n), nil
+}
+
+// text reads character data, up to the next markup, reference, or the end of
+// the current entity.
+func (t *Tokenizer) text() (xml.Token, error) {
+	var b []byte
+	for {
+		c, err := t.peekByte()
+		if err == io.EOF || err == errEntityEnd || (err == nil && (c == '<' || c == '&')) {
+			break
+		}
+
+		r, err := t.readRune()
+		if err != nil {
+			return nil, err
+		}
+
+		if r == '>' && len(b) >= 2 && b[len(b)-1] == ']' && b[len(b)-2] == ']' {
+			return nil, t.syntaxError("']]>' in character data")
+		}
+
+		b = utf8.AppendRune(b, r)
+	}
+
+	if len(t.names) == 0 {
+		for _, c := range b {
+			if !xmlchar.IsSpace(rune(c)) {
+				return nil, t.syntaxError("character data outside the root element")
+			}
+		}
+	}
+
+	return xml.CharData(b), nil
+}
+
+// ref reads the rest of a reference, after its leading '&', and returns it
+// without its trailing ';'.
+func (t *Tokenizer) ref() (string, error) {
+	var ref string
+	if t.hasPrefix("#") {
+		t.skip(len("#"))
+
+		b := []byte("#")
+		for {
+			c, err := t.peekByte()
+			if err != nil || !(c == 'x' || c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+				break
+			}
+
+			t.skip(1)
+			b = append(b, c)
+		}
+
+		ref = string(b)
+	} else {
+		name, err := t.name()
+		if err != nil {
+			return "", err
+		}
+
+		ref = name
+	}
+
+	if !t.hasPrefix(";") {
+		return "", t.syntaxError("expected ';' after reference &%s", ref)
+	}
+
+	t.skip(len(";"))
+	return ref, nil
+}
+
+// until reads characters up to and including end, and returns those before
+// it.
+func (t *Tokenizer) until(end string) (string, error) {
+	var b []byte
+	for !t.hasPrefix(end) {
+		r, err := t.readRune()
+		if err != nil {
+			return "", t.unexpected(err)
+		}
+
+		b = utf8.AppendRune(b, r)
+	}
+
+	t.skip(len(end))
+	return string(b), nil
+}
+
+// name reads a Name.
+func (t *Tokenizer) name() (string, error) {
+	var b []byte
+	for {
+		r, ok := t.peekRune()
+		if !ok || !xmlchar.IsNameRune(r, len(b) == 0) {
+			break
+		}
+
+		t.readRune()
+		b = utf8.AppendRune(b, r)
+	}
+
+	if len(b) == 0 {
+		if _, err := t.peekByte(); err != nil {
+			return "", t.unexpected(err)
+		}
+
+		return "", t.syntaxError("expected a name")
+	}
+
+	return string(b), nil
+}
+
+// qualifiedName reads a Name, and splits it into a prefix and local name as
+// xml.Decoder does.
+func (t *Tokenizer) qualifiedName() (xml.Name, error) {
+	s, err := t.name()
+	if err != nil {
+		return xml.Name{}, err
+	}
+
+	if strings.Count(s, ":") > 1 {
+		return xml.Name{Local: s}, nil
+	}
+
+	if i := strings.IndexByte(s, ':'); i >= 1 && i <= len(s)-2 {
+		return xml.Name{Space: s[:i], Local: s[i+1:]}, nil
+	}
+
+	return xml.Name{Local: s}, nil
+}
+
+// space reads any whitespace, and returns whether there was any.
+func (t *Tokenizer) space() bool {
+	found := false
+	for {
+		c, err := t.peekByte()
+		if err != nil || !xmlchar.IsSpace(rune(c)) {
+			return found
+		}
+
+		t.readRune()
+		found = true
+	}
+}
+
+// readRune reads the next character of the innermost entity being expanded,
+// or of the document if there is none. Line endings in the document are
+// normalized to "\n", including, in an XML 1.1 document, NEL and LS.
+//
+// https://www.w3.org/TR/xml11/#sec-line-ends
+func (t *Tokenizer) readRune() (rune, error) {
+	if n := len(t.frames); n > 0 {
+		f := t.frames[n-1]
+		if f.pos == len(f.text) {
+			return 0, errEntityEnd
+		}
+
+		r, size := utf8.DecodeRuneInString(f.text[f.pos:])
+		f.pos += size
+		return r, nil
+	}
+
+	r, size, err := t.r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+
+	if r == utf8.RuneError && size == 1 {
+		return 0, t.syntaxError("invalid UTF-8")
+	}
+
+	switch {
+	case r == '\r':
+		if b, err := t.r.Peek(2); len(b) > 0 && b[0] == '\n' {
+			t.r.Discard(1)
+		} else if err == nil && t.v11 && string(b) == "\u0085" {
+			t.r.Discard(2)
+		}
+
+		r = '\n'
+	case t.v11 && (r == '\u0085' || r == '\u2028'):
+		r = '\n'
+	}
+
+	if !xmlchar.IsChar(r) || t.v11 && xmlchar.IsRestrictedChar(r) {
+		return 0, t.syntaxError("illegal character U+%04X", r)
+	}
+
+	if r == '\n' {
+		t.line++
+		t.col = 1
+	} else {
+		t.col++
+	}
+
+	return r, nil
+}
+
+// peekByte returns the next byte of the innermost entity being expanded, or of
+// the document, without consuming it.
+func (t *Tokenizer) peekByte() (byte, error) {
+	if n := len(t.frames); n > 0 {
+		f := t.frames[n-1]
+		if f.pos == len(f.text) {
+			return 0, errEntityEnd
+		}
+
+		return f.text[f.pos], nil
+	}
+
+	b, err := t.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return b[0], nil
+}
+
+// peekRune returns the next character without consuming it, or false if there
+// is none or it is not valid UTF-8.
+func (t *Tokenizer) peekRune() (rune, bool) {
+	var b []byte
+	if n := len(t.frames); n > 0 {
+		f := t.frames[n-1]
+		b = []byte(f.text[f.pos:])
+	} else {
+		b, _ = t.r.Peek(utf8.UTFMax)
+	}
+
+	r, size := utf8.DecodeRune(b)
+	if size == 0 || (r == utf8.RuneError && size == 1) {
+		return 0, false
+	}
+
+	return r, true
+}
+
+// hasPrefix returns whether the next characters are s.
+func (t *Tokenizer) hasPrefix(s string) bool {
+	if n := len(t.frames); n > 0 {
+		f := t.frames[n-1]
+		return strings.HasPrefix(f.text[f.pos:], s)
+	}
+
+	b, _ := t.r.Peek(len(s))
+	return string(b) == s
+}
+
+// hasSpaceAt returns whether the byte at offset i of the document is
+// whitespace.
+func (t *Tokenizer) hasSpaceAt(i int) bool {
+	b, _ := t.r.Peek(i + 1)
+	return len(b) > i && xmlchar.IsSpace(rune(b[i]))
+}
+
+// skip consumes the next n bytes, which must be ASCII characters other than
+// line endings, and which hasPrefix has already checked for.
+func (t *Tokenizer) skip(n int) {
+	if k := len(t.frames); k > 0 {
+		t.frames[k-1].pos += n
+		return
+	}
+
+	t.r.Discard(n)
+	t.col += n
+}
+
+// entity returns the name of the innermost entity being expanded.
+func (t *Tokenizer) entity() string {
+	if n := len(t.frames); n > 0 {
+		return t.frames[n-1].name
+	}
+
+	return ""
+}
+
+// unexpected converts an error from reading within a token into a syntax
+// error, if it signals that the input ended.
+func (t *Tokenizer) unexpected(err error) error {
+	switch err {
+	case io.EOF:
+		return t.syntaxError("unexpected EOF")
+	case errEntityEnd:
+		return t.syntaxError("unexpected end of entity %q", t.entity())
+	default:
+		return err
+	}
+}
+
+func (t *Tokenizer) syntaxError(format string, args ...interface{}) error {
+	return &xml.SyntaxError{
+		Msg:  fmt.Sprintf("column %d: %s", t.col, fmt.Sprintf(format, args...)),
+		Line: t.line,
+	}
+}
+
+// qualify returns the qualified name of n, as written in a document.
+func qualify(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+
+	return n.Space + ":" + n.Local
+}
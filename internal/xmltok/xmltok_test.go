@@ -0,0 +1,345 @@
+package xmltok_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n/internal/corpus"
+	"github.com/ucarion/c14n/internal/xmltok"
+	"golang.org/x/net/html/charset"
+)
+
+// TestTokenizer_Decoder checks that the tokenizer reads the same tokens as
+// xml.Decoder from every well-formed document the decoder also accepts.
+func TestTokenizer_Decoder(t *testing.T) {
+	inputs := map[string][]byte{
+		"saml":       corpus.SAMLAssertion(),
+		"flat":       corpus.Flat(100),
+		"deep":       corpus.Deep(100),
+		"namespaces": corpus.Namespaces(100),
+		"escaping":   corpus.Escaping(100),
+		"misc": []byte(`<?xml version='1.0' encoding="utf-8" standalone='yes'?>
+<!DOCTYPE doc SYSTEM "doc.dtd">
+<?pi?><?pi   with data ?>
+<doc a = "1" b:c='2&#x9;&lt;&amp;&gt;&quot;&apos;'><![CDATA[<x> & ]]]]><![CDATA[>]]>&#169;<empty/><é:ü/></doc>
+<!-- a comment -->`),
+	}
+
+	paths, err := filepath.Glob("../../tests/*/in.xml")
+	assert.NoError(t, err)
+
+	for _, path := range paths {
+		in, err := os.ReadFile(path)
+		assert.NoError(t, err)
+
+		inputs[filepath.Base(filepath.Dir(path))] = in
+	}
+
+	// xml.Decoder's RawToken method accepts these documents, though they are
+	// not well-formed.
+	malformed := map[string]bool{
+		"error_multiple_roots": true, // a second root element
+		"error_unbalanced":     true, // mismatched end tag
+		"procinst":             true, // an XML declaration after the start
+	}
+
+	for name, in := range inputs {
+		t.Run(name, func(t *testing.T) {
+			decoder := xml.NewDecoder(bytes.NewReader(in))
+			decoder.CharsetReader = charset.NewReaderLabel
+
+			want, err := readAll(decoder)
+			if err != nil {
+				t.Skipf("decoder rejects input: %v", err)
+			}
+
+			tokenizer := xmltok.New(bytes.NewReader(in))
+			tokenizer.CharsetReader = charset.NewReaderLabel
+
+			got, err := readAll(tokenizer)
+			if malformed[name] {
+				assert.IsType(t, &xml.SyntaxError{}, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+// readAll reads every token from r, and normalizes them for comparison:
+// adjacent CharData tokens are merged, and comments within directives, which
+// xml.Decoder replaces with a space, are dropped.
+func readAll(r interface{ RawToken() (xml.Token, error) }) ([]xml.Token, error) {
+	var tokens []xml.Token
+	for {
+		tok, err := r.RawToken()
+		if err == io.EOF {
+			return tokens, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		tok = xml.CopyToken(tok)
+		switch tok := tok.(type) {
+		case xml.CharData:
+			if n := len(tokens); n > 0 {
+				if prev, ok := tokens[n-1].(xml.CharData); ok {
+					tokens[n-1] = append(prev, tok...)
+					continue
+				}
+			}
+		case xml.Directive:
+			s := string(tok)
+			for {
+				start := strings.Index(s, "<!--")
+				if start < 0 {
+					break
+				}
+
+				end := strings.Index(s[start:], "-->")
+				s = s[:start] + s[start+end+3:]
+			}
+
+			tokens = append(tokens, xml.Directive(strings.Join(strings.Fields(s), " ")))
+			continue
+		}
+
+		tokens = append(tokens, tok)
+	}
+}
+
+func TestTokenizer_Entities(t *testing.T) {
+	input := `<!DOCTYPE doc [
+		<!ENTITY text "a &amp; b">
+		<!ENTITY markup "<b title='&text;'>&text;</b>&#x26;lt;">
+		<!ENTITY external SYSTEM "ext.xml">
+		<!ENTITY space "&#9;x&#xA;">
+	]>
+	<doc attr="&markup.attr;" space="&space;">&markup;&external;</doc>`
+
+	input = strings.Replace(input, "&markup.attr;", "&text;", 1)
+
+	tokenizer := xmltok.New(strings.NewReader(input))
+	tokenizer.Resolve = func(publicID, systemID string) ([]byte, error) {
+		assert.Equal(t, "ext.xml", systemID)
+		return []byte("<?xml encoding='UTF-8'?><c>&text;</c>\r\n"), nil
+	}
+
+	var tokens []xml.Token
+	var entities []string
+	for {
+		tok, err := tokenizer.RawToken()
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(t, err)
+		if _, ok := tok.(xml.Directive); ok {
+			continue
+		}
+
+		tokens = append(tokens, xml.CopyToken(tok))
+		entities = append(entities, tokenizer.Info().Entity)
+	}
+
+	name := func(local string) xml.Name { return xml.Name{Local: local} }
+	assert.Equal(t, []xml.Token{
+		xml.CharData("\n\t"),
+		xml.StartElement{Name: name("doc"), Attr: []xml.Attr{
+			{Name: name("attr"), Value: "a & b"},
+			{Name: name("space"), Value: " x "},
+		}},
+		xml.StartElement{Name: name("b"), Attr: []xml.Attr{{Name: name("title"), Value: "a & b"}}},
+		xml.CharData("a "),
+		xml.CharData("&"),
+		xml.CharData(" b"),
+		xml.EndElement{Name: name("b")},
+		xml.CharData("<"),
+		xml.StartElement{Name: name("c"), Attr: []xml.Attr{}},
+		xml.CharData("a "),
+		xml.CharData("&"),
+		xml.CharData(" b"),
+		xml.EndElement{Name: name("c")},
+		xml.CharData("\n"),
+		xml.EndElement{Name: name("doc")},
+	}, tokens)
+
+	assert.Equal(t, []string{
+		"", "", "markup", "text", "text", "text", "markup", "markup",
+		"external", "text", "text", "text", "external", "external", "",
+	}, entities)
+}
+
+func TestTokenizer_ResolveOnce(t *testing.T) {
+	input := `<!DOCTYPE doc [
+		<!ENTITY external SYSTEM "ext.xml">
+		<!ENTITY unused SYSTEM "unused.xml">
+	]><doc>&external;&external;&external;</doc>`
+
+	var resolved []string
+	tokenizer := xmltok.New(strings.NewReader(input))
+	tokenizer.Resolve = func(publicID, systemID string) ([]byte, error) {
+		resolved = append(resolved, systemID)
+		return []byte("x"), nil
+	}
+
+	tokens, err := readAll(tokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, xml.CharData("xxx"), tokens[2])
+	assert.Equal(t, []string{"ext.xml"}, resolved)
+}
+
+func TestTokenizer_Info(t *testing.T) {
+	input := "<doc a=\"1\"\r\n b='2'>x&#x20;<![CDATA[y]]>&lt;</doc>"
+	tokenizer := xmltok.New(strings.NewReader(input))
+
+	var infos []xmltok.Info
+	for {
+		_, err := tokenizer.RawToken()
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(t, err)
+		infos = append(infos, tokenizer.Info())
+	}
+
+	assert.Equal(t, []xmltok.Info{
+		{Line: 1, Column: 1, Quotes: []byte(`"'`)},
+		{Line: 2, Column: 8},
+		{Line: 2, Column: 9, Reference: "#x20"},
+		{Line: 2, Column: 15, CDATA: true},
+		{Line: 2, Column: 28, Reference: "lt"},
+		{Line: 2, Column: 32},
+	}, infos)
+}
+
+func TestTokenizer_Normalization(t *testing.T) {
+	input := "<doc a=\"x\r\ny\tz&#xD;&#x9;\">\r\na\rb&#xD;</doc>"
+	tokenizer := xmltok.New(strings.NewReader(input))
+
+	tokens, err := readAll(tokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, []xml.Token{
+		xml.StartElement{Name: xml.Name{Local: "doc"}, Attr: []xml.Attr{{Name: xml.Name{Local: "a"}, Value: "x y z\r\t"}}},
+		xml.CharData("\na\nb\r"),
+		xml.EndElement{Name: xml.Name{Local: "doc"}},
+	}, tokens)
+}
+
+func TestTokenizer_XML11(t *testing.T) {
+	input := "<?xml version=\"1.1\"?><doc a=\"x\u0085y&#x1;\">a\u0085b\u2028c\r\u0085d&#x7;&#x85;</doc>"
+	tokenizer := xmltok.New(strings.NewReader(input))
+	tokenizer.XML11 = true
+
+	tokens, err := readAll(tokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, []xml.Token{
+		xml.ProcInst{Target: "xml", Inst: []byte(`version="1.1"`)},
+		xml.StartElement{Name: xml.Name{Local: "doc"}, Attr: []xml.Attr{{Name: xml.Name{Local: "a"}, Value: "x y\x01"}}},
+		xml.CharData("a\nb\nc\nd\x07\u0085"),
+		xml.EndElement{Name: xml.Name{Local: "doc"}},
+	}, tokens)
+
+	// Control characters must still be written as references, and are only
+	// allowed in XML 1.1 documents.
+	for _, input := range []string{
+		"<?xml version=\"1.1\"?><doc>\u0080</doc>",
+		"<?xml version=\"1.0\"?><doc>&#x7;</doc>",
+		"<doc>&#x7;</doc>",
+	} {
+		tokenizer := xmltok.New(strings.NewReader(input))
+		tokenizer.XML11 = true
+
+		_, err := readAll(tokenizer)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestTokenizer_Limit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(`<!DOCTYPE lolz [<!ENTITY lol0 "lol">`)
+	for i := 1; i < 10; i++ {
+		b.WriteString(`<!ENTITY lol` + string(rune('0'+i)) + ` "` + strings.Repeat(`&lol`+string(rune('0'+i-1))+`;`, 10) + `">`)
+	}
+	b.WriteString(`]><lolz>&lol9;</lolz>`)
+
+	tokenizer := xmltok.New(strings.NewReader(b.String()))
+	_, err := readAll(tokenizer)
+	assert.Equal(t, xmltok.ErrLimit, err)
+
+	// Expanding lol3 reads the 60 bytes of its replacement text once, the 60
+	// bytes of lol2's 10 times, lol1's 100 times, and lol0's 3 bytes 1000
+	// times.
+	input := strings.Replace(b.String(), "&lol9;", "&lol3;", 1)
+
+	tokenizer = xmltok.New(strings.NewReader(input))
+	tokenizer.MaxEntityExpansion = 60 + 600 + 6000 + 3000
+	_, err = readAll(tokenizer)
+	assert.NoError(t, err)
+
+	tokenizer = xmltok.New(strings.NewReader(input))
+	tokenizer.MaxEntityExpansion = 60 + 600 + 6000 + 3000 - 1
+	_, err = readAll(tokenizer)
+	assert.Equal(t, xmltok.ErrLimit, err)
+}
+
+func TestTokenizer_Error(t *testing.T) {
+	testCases := map[string]string{
+		``:                                 "",
+		`<doc>`:                            "line 1: column 6: unexpected EOF",
+		`<doc></DOC>`:                      "line 1: column 12: element <doc> closed by </DOC>",
+		`</doc>`:                           "line 1: column 7: unexpected end element </doc>",
+		`<doc/><doc/>`:                     "line 1: column 7: content after the root element",
+		`text<doc/>`:                       "line 1: column 5: character data outside the root element",
+		`<doc/>&amp;`:                      "line 1: column 12: reference &amp; outside the root element",
+		`<doc a="1" a="2"/>`:               `line 1: column 13: attribute "a" appears more than once`,
+		`<doc a="<"/>`:                     "line 1: column 10: '<' in attribute value",
+		`<doc a=1/>`:                       `line 1: column 8: unquoted value for attribute "a"`,
+		`<doc a="1"b="2"/>`:                "line 1: column 11: expected whitespace before attribute",
+		`<doc>]]></doc>`:                   "line 1: column 9: ']]>' in character data",
+		`<doc><!-- a -- b --></doc>`:       "line 1: column 15: '--' in comment",
+		`<doc>&nope;</doc>`:                "line 1: column 12: undeclared entity &nope;",
+		`<doc>&amp</doc>`:                  "line 1: column 10: expected ';' after reference &amp",
+		`<doc>&#0;</doc>`:                  "line 1: column 10: invalid character reference &#0;",
+		`<doc>&#xD800;</doc>`:              "line 1: column 14: invalid character reference &#xD800;",
+		`<doc>` + "\x01" + `</doc>`:        "line 1: column 6: illegal character U+0001",
+		`<doc>` + "\xff" + `</doc>`:        "line 1: column 6: invalid UTF-8",
+		`<doc><?xml version="1.0"?></doc>`: `line 1: column 11: processing instruction target "xml" is reserved`,
+		`<?xml version="1.1"?><doc/>`:      `line 1: column 22: unsupported version "1.1"; only version 1.0 is supported`,
+		`<?xml version="1.0" encoding="latin1"?><doc/>`:                       `line 1: column 40: unsupported encoding "latin1"`,
+		`<?xml encoding="UTF-8"?><doc/>`:                                      "line 1: column 25: XML declaration lacks a version",
+		`<doc><!DOCTYPE doc></doc>`:                                           "line 1: column 6: unexpected document type declaration",
+		`<!DOCTYPE doc [<!ENTITY a "<a>">]><doc>&a;</doc>`:                    `line 1: column 43: element in entity "a" is not closed within it`,
+		`<!DOCTYPE doc [<!ENTITY a "</doc>">]><doc>&a;`:                       `line 1: column 46: end element </doc> in entity "a" closes an element opened outside it`,
+		`<!DOCTYPE doc [<!ENTITY a "&b;"><!ENTITY b "&a;">]><doc>&a;</doc>`:   `line 1: column 60: entity "a" refers to itself`,
+		`<!DOCTYPE doc [<!ENTITY a "&a;">]><doc a="&a;"/>`:                    `line 1: column 46: entity "a" refers to itself`,
+		`<!DOCTYPE doc [<!ENTITY a "<">]><doc a="&a;"/>`:                      `line 1: column 44: '<' in replacement text of entity "a", referenced in attribute value`,
+		`<!DOCTYPE doc [<!ENTITY a SYSTEM "a.xml">]><doc>&a;</doc>`:           "line 1: column 52: cannot resolve external entity &a;",
+		`<!DOCTYPE doc [<!ENTITY a SYSTEM "a.xml">]><doc a="&a;"/>`:           "line 1: column 55: reference to external entity &a; in attribute value",
+		`<!DOCTYPE doc [<!ENTITY a SYSTEM "a.gif" NDATA gif>]><doc>&a;</doc>`: "line 1: column 62: reference to unparsed entity &a;",
+		`<!DOCTYPE doc [<!BOGUS>]><doc/>`:                                     "line 1: column 26: dtd: offset 13: unexpected \"<\"",
+	}
+
+	for input, msg := range testCases {
+		t.Run(input, func(t *testing.T) {
+			_, err := readAll(xmltok.New(strings.NewReader(input)))
+			if msg == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.EqualError(t, err, "XML syntax error on "+msg)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package c14n
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+)
+
+// Limits bounds the resources canonicalization may consume. Inputs that exceed
+// a limit are rejected with a *LimitError, protecting programs that
+// canonicalize untrusted documents from resource exhaustion.
+//
+// A zero or negative field means there is no limit. The zero value imposes no
+// limits at all.
+type Limits struct {
+	// MaxDepth is the maximum depth of element nesting. The root element is at
+	// depth one.
+	MaxDepth int
+
+	// MaxAttrs is the maximum number of attributes on a single element, not
+	// counting namespace declarations.
+	MaxAttrs int
+
+	// MaxNamespaces is the maximum number of namespace declarations on a single
+	// element.
+	MaxNamespaces int
+
+	// MaxOutputBytes is the maximum length of the canonical output. Character
+	// data held for NormalizeNFC, NormalizeLineEndings or TrimTextNodes counts
+	// toward it as it is read, even if trimming later shortens it.
+	MaxOutputBytes int
+
+	// MaxTokens is the maximum number of tokens to read from the input,
+	// including any tokens before the root element.
+	MaxTokens int
+
+	// MaxEntityExpansion is the maximum total size, in bytes, of the
+	// replacement text produced by expanding the entities declared in a DTD.
+	// Expanding the declarations themselves, and expanding the references in
+	// the document, are each bounded by it. It only applies when a
+	// Canonicalizer has ProcessDTD set, and unlike the other limits, zero
+	// means DefaultMaxEntityExpansion. A Tokenizer expands entities itself,
+	// and has a MaxEntityExpansion of its own.
+	MaxEntityExpansion int
+}
+
+// DefaultMaxEntityExpansion is the value of Limits.MaxEntityExpansion used when
+// none is given.
+const DefaultMaxEntityExpansion = 1 << 20
+
+// LimitError is returned when an input exceeds one of the Limits given to a
+// Canonicalizer, or the MaxEntityExpansion of a Tokenizer.
+type LimitError struct {
+	// Limit is the name of the field of Limits or Tokenizer that was exceeded,
+	// such as "MaxDepth".
+	Limit string
+
+	// Max is the value of the exceeded limit.
+	Max int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("c14n: input exceeds %s of %d", e.Limit, e.Max)
+}
+
+// CanonicalizeContext is like Canonicalize, but gives up if ctx is done or if
+// the input exceeds limits. If ctx is done, CanonicalizeContext returns
+// ctx.Err(). If a limit is exceeded, CanonicalizeContext returns a
+// *LimitError.
+//
+// Input is read no further than is necessary to detect that a limit has been
+// exceeded.
+func CanonicalizeContext(ctx context.Context, r RawTokenReader, limits Limits) ([]byte, error) {
+	c := getCanonicalizer()
+	defer putCanonicalizer(c)
+
+	c.Limits = limits
+	defer func() { c.Limits = Limits{} }()
+
+	return c.CanonicalizeContext(ctx, r)
+}
+
+// checkInput enforces the limits that apply to input tokens.
+func (e *encoder) checkInput(t xml.Token) error {
+	e.tokens++
+	if e.limits.MaxTokens > 0 && e.tokens > e.limits.MaxTokens {
+		return &LimitError{Limit: "MaxTokens", Max: e.limits.MaxTokens}
+	}
+
+	start, ok := t.(xml.StartElement)
+	if !ok {
+		return nil
+	}
+
+	if e.limits.MaxDepth > 0 && e.knownNames.Len() >= e.limits.MaxDepth {
+		return &LimitError{Limit: "MaxDepth", Max: e.limits.MaxDepth}
+	}
+
+	var attrs, namespaces int
+	for _, attr := range start.Attr {
+		if _, ok := getNamespace(attr); ok {
+			namespaces++
+		} else {
+			attrs++
+		}
+	}
+
+	if e.limits.MaxAttrs > 0 && attrs > e.limits.MaxAttrs {
+		return &LimitError{Limit: "MaxAttrs", Max: e.limits.MaxAttrs}
+	}
+
+	if e.limits.MaxNamespaces > 0 && namespaces > e.limits.MaxNamespaces {
+		return &LimitError{Limit: "MaxNamespaces", Max: e.limits.MaxNamespaces}
+	}
+
+	return nil
+}
+
+// checkOutput enforces the limits that apply to the output. Character data
+// held by bufferText counts toward MaxOutputBytes as it accumulates, rather
+// than once it is rendered, since it is held in memory all the same.
+func (e *encoder) checkOutput() error {
+	if e.limits.MaxOutputBytes > 0 && e.buf.Len()+len(e.text) > e.limits.MaxOutputBytes {
+		return &LimitError{Limit: "MaxOutputBytes", Max: e.limits.MaxOutputBytes}
+	}
+
+	return nil
+}
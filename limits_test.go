@@ -0,0 +1,153 @@
+package c14n_test
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleCanonicalizeContext() {
+	input := `<a><b><c><d /></c></b></a>`
+	decoder := xml.NewDecoder(strings.NewReader(input))
+	out, err := c14n.CanonicalizeContext(context.Background(), decoder, c14n.Limits{MaxDepth: 3})
+	fmt.Println(string(out), err)
+	// Output:
+	//  c14n: input exceeds MaxDepth of 3
+}
+
+func TestCanonicalizeContext_Limits(t *testing.T) {
+	type testCase struct {
+		In     string
+		Limits c14n.Limits
+		Err    error
+	}
+
+	testCases := []testCase{
+		testCase{
+			In:     `<a><b><c /></b></a>`,
+			Limits: c14n.Limits{MaxDepth: 3},
+		},
+		testCase{
+			In:     `<a><b><c><d /></c></b></a>`,
+			Limits: c14n.Limits{MaxDepth: 3},
+			Err:    &c14n.LimitError{Limit: "MaxDepth", Max: 3},
+		},
+		testCase{
+			In:     `<a x="1" y="2" xmlns:z="http://example.com" />`,
+			Limits: c14n.Limits{MaxAttrs: 2},
+		},
+		testCase{
+			In:     `<a><b x="1" y="2" z="3" /></a>`,
+			Limits: c14n.Limits{MaxAttrs: 2},
+			Err:    &c14n.LimitError{Limit: "MaxAttrs", Max: 2},
+		},
+		testCase{
+			In:     `<a xmlns="http://example.com" xmlns:x="http://example.com" y="1" />`,
+			Limits: c14n.Limits{MaxNamespaces: 2},
+		},
+		testCase{
+			In:     `<a xmlns="http://example.com" xmlns:x="http://example.com" xmlns:y="http://example.com" />`,
+			Limits: c14n.Limits{MaxNamespaces: 2},
+			Err:    &c14n.LimitError{Limit: "MaxNamespaces", Max: 2},
+		},
+		testCase{
+			In:     `<a>0123456789</a>`,
+			Limits: c14n.Limits{MaxOutputBytes: 17},
+		},
+		testCase{
+			In:     `<a>0123456789</a>`,
+			Limits: c14n.Limits{MaxOutputBytes: 16},
+			Err:    &c14n.LimitError{Limit: "MaxOutputBytes", Max: 16},
+		},
+		testCase{
+			In:     `<!-- leading --><a>b</a>`,
+			Limits: c14n.Limits{MaxTokens: 4},
+		},
+		testCase{
+			In:     `<!-- leading --><a>b</a>`,
+			Limits: c14n.Limits{MaxTokens: 3},
+			Err:    &c14n.LimitError{Limit: "MaxTokens", Max: 3},
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			decoder := xml.NewDecoder(strings.NewReader(tt.In))
+			_, err := c14n.CanonicalizeContext(context.Background(), decoder, tt.Limits)
+			assert.Equal(t, tt.Err, err)
+		})
+	}
+}
+
+func TestCanonicalizer_Limits_BufferedText(t *testing.T) {
+	tokens := c14n.TokenSlice{xml.StartElement{Name: xml.Name{Local: "a"}}}
+	for i := 0; i < 100; i++ {
+		tokens = append(tokens, xml.CharData("0123456789"))
+	}
+
+	tokens = append(tokens, xml.EndElement{Name: xml.Name{Local: "a"}})
+
+	// The text is held until the end element, but counts toward the limit as
+	// soon as it is read.
+	c := c14n.Canonicalizer{NormalizeNFC: true, Limits: c14n.Limits{MaxOutputBytes: 50}}
+	_, err := c.Canonicalize(&tokens)
+	assert.Equal(t, &c14n.LimitError{Limit: "MaxOutputBytes", Max: 50}, err)
+	assert.Len(t, tokens, 96) // "<a>" and five tokens of text exceed the limit
+}
+
+func TestCanonicalizeContext_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Cancel the context once the reader has produced a few tokens. The
+	// canonicalization should stop before it reads any more.
+	r := &cancelingRawTokener{cancel: cancel, after: 3}
+	_, err := c14n.CanonicalizeContext(ctx, r, c14n.Limits{})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 3, r.count)
+}
+
+func TestCanonicalizer_Limits(t *testing.T) {
+	c := c14n.Canonicalizer{Limits: c14n.Limits{MaxDepth: 1}}
+
+	_, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(`<a><b /></a>`)))
+	assert.Equal(t, &c14n.LimitError{Limit: "MaxDepth", Max: 1}, err)
+
+	out, err := c.Canonicalize(xml.NewDecoder(strings.NewReader(`<a></a>`)))
+	assert.NoError(t, err)
+	assert.Equal(t, `<a></a>`, string(out))
+}
+
+func TestBatch_Limits(t *testing.T) {
+	inputs := []c14n.RawTokenReader{
+		xml.NewDecoder(strings.NewReader(`<a />`)),
+		xml.NewDecoder(strings.NewReader(`<a><b /></a>`)),
+	}
+
+	results := c14n.Batch{Limits: c14n.Limits{MaxDepth: 1}}.Canonicalize(context.Background(), inputs)
+	assert.Equal(t, []c14n.Result{
+		c14n.Result{Canonical: []byte(`<a></a>`)},
+		c14n.Result{Err: &c14n.LimitError{Limit: "MaxDepth", Max: 1}},
+	}, results)
+}
+
+// cancelingRawTokener produces an endless sequence of nested elements, calling
+// cancel once it has produced a given number of them.
+type cancelingRawTokener struct {
+	cancel func()
+	after  int
+	count  int
+}
+
+func (c *cancelingRawTokener) RawToken() (xml.Token, error) {
+	c.count++
+	if c.count == c.after {
+		c.cancel()
+	}
+
+	return xml.StartElement{Name: xml.Name{Local: "a"}}, nil
+}
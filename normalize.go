@@ -0,0 +1,111 @@
+package c14n
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizesText returns whether character data is normalized or trimmed
+// before it is rendered, and so must be held by bufferText.
+func (e *encoder) normalizesText() bool {
+	return e.nfc || e.lineEndings || e.trim
+}
+
+// normalizeAttrs returns t with its attribute values normalized as requested:
+// line endings first, and then Unicode Normalization Form C. If they are
+// already normalized, t is returned as-is.
+func (e *encoder) normalizeAttrs(t xml.StartElement) xml.StartElement {
+	for i, attr := range t.Attr {
+		if e.normalizeString(attr.Value) == attr.Value {
+			continue
+		}
+
+		return mapAttrValues(t, i, e.normalizeString)
+	}
+
+	return t
+}
+
+// normalizeString normalizes an attribute value as requested.
+func (e *encoder) normalizeString(s string) string {
+	if e.lineEndings && e.version11 && strings.ContainsAny(s, "\r\u0085\u2028") {
+		s = string(normalizeLineEndings11([]byte(s)))
+	} else if e.lineEndings && strings.IndexByte(s, '\r') >= 0 {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+		s = strings.ReplaceAll(s, "\r", "\n")
+	}
+
+	if e.nfc && !norm.NFC.IsNormalString(s) {
+		s = norm.NFC.String(s)
+	}
+
+	return s
+}
+
+// bufferText holds character data until the next token that is not character
+// data, so that text split across several tokens is normalized as a whole. A
+// base character and the combining mark that follows it may be in separate
+// tokens, if the mark was written as a character reference; so may the
+// carriage return and line feed of a CRLF line ending.
+func (e *encoder) bufferText(t xml.CharData) {
+	e.text = append(e.text, t...)
+}
+
+// flushText renders the character data held by bufferText, normalized and
+// trimmed as requested.
+func (e *encoder) flushText() {
+	if len(e.text) == 0 {
+		return
+	}
+
+	text := e.text
+	if e.lineEndings && e.version11 {
+		text = normalizeLineEndings11(text)
+	} else if e.lineEndings {
+		text = normalizeLineEndings(text)
+	}
+
+	if e.trim {
+		text = e.trimText(text)
+	}
+
+	if e.nfc {
+		e.nfcText = norm.NFC.Append(e.nfcText[:0], text...)
+		text = e.nfcText
+	}
+
+	e.text = e.text[:0]
+	if len(text) > 0 {
+		e.charData(text)
+	}
+}
+
+// normalizeLineEndings applies the end-of-line handling of XML 1.0 to s in
+// place: each CRLF sequence, and each CR not followed by LF, is replaced by a
+// single LF. It returns the shortened slice.
+//
+// https://www.w3.org/TR/xml/#sec-line-ends
+func normalizeLineEndings(s []byte) []byte {
+	i := bytes.IndexByte(s, '\r')
+	if i < 0 {
+		return s
+	}
+
+	out := s[:i]
+	for ; i < len(s); i++ {
+		if s[i] != '\r' {
+			out = append(out, s[i])
+			continue
+		}
+
+		out = append(out, '\n')
+		if i+1 < len(s) && s[i+1] == '\n' {
+			i++
+		}
+	}
+
+	return out
+}
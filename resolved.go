@@ -0,0 +1,140 @@
+package c14n
+
+import (
+	"encoding/xml"
+)
+
+// xmlNamespace is the namespace URI permanently bound to the "xml" prefix.
+//
+// https://www.w3.org/TR/xml-names/#ns-decl
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// NewResolvedReader adapts an xml.TokenReader, whose tokens have namespace URIs
+// rather than prefixes in Name.Space, into a RawTokenReader suitable for
+// Canonicalize.
+//
+// xml.Decoder's Token method, xml.NewTokenDecoder, and most transforms built on
+// xml.TokenReader produce such tokens. Those tokens still carry the original
+// namespace declarations (xmlns and xmlns:prefix attributes), and the returned
+// reader uses those declarations to recover the prefix each name was written
+// with. A name whose namespace URI is not in scope is passed through unchanged.
+//
+// Canonicalizing the returned reader produces the same output as
+// canonicalizing the original document, so long as no namespace URI is bound
+// to more than one prefix at once. When a URI is bound to several prefixes,
+// elements prefer the default namespace, and otherwise the most recently
+// declared prefix is used.
+func NewResolvedReader(r xml.TokenReader) RawTokenReader {
+	return &resolvedReader{r: r}
+}
+
+type resolvedReader struct {
+	r        xml.TokenReader
+	bindings []binding  // namespace declarations in scope, in document order
+	scopes   []int      // the length of bindings when each open element started
+	names    []xml.Name // the prefixed names of each open element
+}
+
+// binding is a single namespace declaration.
+type binding struct {
+	prefix string
+	uri    string
+}
+
+func (r *resolvedReader) RawToken() (xml.Token, error) {
+	t, err := r.r.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := t.(type) {
+	case xml.StartElement:
+		r.scopes = append(r.scopes, len(r.bindings))
+		for _, attr := range t.Attr {
+			if name, ok := getNamespace(attr); ok {
+				r.bindings = append(r.bindings, binding{prefix: name, uri: attr.Value})
+			}
+		}
+
+		// Construct a new StartElement rather than modifying t in place, because
+		// t.Attr may be shared with the underlying reader.
+		start := xml.StartElement{
+			Name: xml.Name{Space: r.prefix(t.Name.Space, false), Local: t.Name.Local},
+			Attr: make([]xml.Attr, len(t.Attr)),
+		}
+
+		for i, attr := range t.Attr {
+			if _, ok := getNamespace(attr); ok {
+				start.Attr[i] = attr
+			} else {
+				start.Attr[i] = xml.Attr{
+					Name:  xml.Name{Space: r.prefix(attr.Name.Space, true), Local: attr.Name.Local},
+					Value: attr.Value,
+				}
+			}
+		}
+
+		r.names = append(r.names, start.Name)
+		return start, nil
+	case xml.EndElement:
+		// Use the same name as the corresponding StartElement, so that the output
+		// is balanced even if the prefix cannot be recovered.
+		if len(r.names) == 0 {
+			return t, nil
+		}
+
+		end := xml.EndElement{Name: r.names[len(r.names)-1]}
+		r.names = r.names[:len(r.names)-1]
+		r.bindings = r.bindings[:r.scopes[len(r.scopes)-1]]
+		r.scopes = r.scopes[:len(r.scopes)-1]
+		return end, nil
+	default:
+		return t, nil
+	}
+}
+
+// prefix returns the prefix bound to uri in the current scope. Attributes are
+// never in the default namespace, so only elements may use the empty prefix for
+// a non-empty uri.
+func (r *resolvedReader) prefix(uri string, attr bool) string {
+	if uri == "" {
+		return ""
+	}
+
+	if uri == xmlNamespace {
+		return "xml"
+	}
+
+	if !attr {
+		if def, ok := r.lookup(""); ok && def == uri {
+			return ""
+		}
+	}
+
+	for i := len(r.bindings) - 1; i >= 0; i-- {
+		b := r.bindings[i]
+		if b.uri != uri || b.prefix == "" {
+			continue
+		}
+
+		// Make sure this declaration hasn't since been shadowed by another
+		// declaration for the same prefix.
+		if current, _ := r.lookup(b.prefix); current == uri {
+			return b.prefix
+		}
+	}
+
+	return uri
+}
+
+// lookup returns the URI currently bound to prefix, and whether it's bound at
+// all.
+func (r *resolvedReader) lookup(prefix string) (string, bool) {
+	for i := len(r.bindings) - 1; i >= 0; i-- {
+		if r.bindings[i].prefix == prefix {
+			return r.bindings[i].uri, true
+		}
+	}
+
+	return "", false
+}
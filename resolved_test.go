@@ -0,0 +1,96 @@
+package c14n_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+	"golang.org/x/net/html/charset"
+)
+
+func ExampleNewResolvedReader() {
+	input := `<a:foo xmlns:a="http://example.com" z="2" a:y="1"><a:bar /></a:foo>`
+	decoder := xml.NewTokenDecoder(xml.NewDecoder(strings.NewReader(input)))
+	out, err := c14n.Canonicalize(c14n.NewResolvedReader(decoder))
+	fmt.Println(string(out), err)
+	// Output:
+	// <a:foo xmlns:a="http://example.com" z="2" a:y="1"><a:bar></a:bar></a:foo> <nil>
+}
+
+func TestNewResolvedReader(t *testing.T) {
+	for _, tt := range readTestCases(t) {
+		t.Run(tt.Name, func(t *testing.T) {
+			if tt.Options.Skip != "" {
+				t.Skip(tt.Options.Skip)
+			}
+
+			if tt.Options.TokenizerOnly != "" {
+				t.Skip(tt.Options.TokenizerOnly)
+			}
+
+			// Entities are expanded by setting the Entity field of an
+			// xml.Decoder, which the resolved reader hides.
+			if tt.Options.DTD {
+				t.Skip("test case expands entities")
+			}
+
+			decoder := xml.NewDecoder(bytes.NewReader(tt.In))
+			decoder.CharsetReader = charset.NewReaderLabel
+
+			c := tt.Options.canonicalizer(t, tt.Dir)
+			actual, err := c.Canonicalize(c14n.NewResolvedReader(decoder))
+
+			// The decoder reports malformed input itself when resolving names,
+			// so the error messages may differ.
+			if tt.Options.Error != "" {
+				assert.Error(t, err)
+				return
+			}
+
+			tt.check(t, actual, err)
+		})
+	}
+}
+
+func TestNewResolvedReader_Prefixes(t *testing.T) {
+	type testCase struct {
+		In  string
+		Out string
+	}
+
+	testCases := []testCase{
+		// The xml prefix is bound without being declared.
+		testCase{
+			In:  `<foo xml:lang="en"></foo>`,
+			Out: `<foo xml:lang="en"></foo>`,
+		},
+		// Undeclared prefixes are passed through.
+		testCase{
+			In:  `<a:foo b:bar="baz"></a:foo>`,
+			Out: `<a:foo b:bar="baz"></a:foo>`,
+		},
+		// Attributes never use the default namespace.
+		testCase{
+			In:  `<foo xmlns="http://example.com" xmlns:a="http://example.com" a:bar="baz"></foo>`,
+			Out: `<foo xmlns="http://example.com" xmlns:a="http://example.com" a:bar="baz"></foo>`,
+		},
+		// The most recently declared prefix is used, unless it's been shadowed.
+		testCase{
+			In:  `<b:foo xmlns:b="http://example.com/1"><a:bar xmlns:a="http://example.com/1"><b:baz xmlns:a="http://example.com/2"></b:baz></a:bar></b:foo>`,
+			Out: `<b:foo xmlns:b="http://example.com/1"><a:bar xmlns:a="http://example.com/1"><b:baz></b:baz></a:bar></b:foo>`,
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			decoder := xml.NewDecoder(strings.NewReader(tt.In))
+			out, err := c14n.Canonicalize(c14n.NewResolvedReader(decoder))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.Out, string(out))
+		})
+	}
+}
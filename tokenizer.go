@@ -0,0 +1,126 @@
+package c14n
+
+import (
+	"encoding/xml"
+	"io"
+
+	"github.com/ucarion/c14n/internal/xmltok"
+)
+
+// Tokenizer is a RawTokenReader that reads an XML 1.0 document. It is an
+// alternative to xml.Decoder that follows the XML spec more closely:
+//
+//   - Documents that are not well-formed are rejected with an
+//     *xml.SyntaxError. This includes mismatched end tags, duplicate
+//     attributes, and characters outside the XML Char production, all of which
+//     xml.Decoder's RawToken method accepts.
+//   - References to the entities declared in the document's internal subset
+//     are expanded, even when their replacement text contains markup, and
+//     whether or not a Canonicalizer has ProcessDTD set. External entities are
+//     resolved with the Tokenizer's EntityResolver. Expansion is bounded by
+//     its MaxEntityExpansion.
+//   - Line endings are normalized everywhere, and whitespace characters in
+//     attribute values are replaced with spaces, as the spec requires.
+//     xml.Decoder leaves literal tabs and newlines in attribute values as
+//     they are.
+//
+// Its tokens otherwise have the same form as those of xml.Decoder's RawToken
+// method, so canonicalizing a well-formed document without a DTD produces the
+// same output with either. Character data may be split into several CharData
+// tokens, which Canonicalize renders as one.
+//
+// Documents that declare a version other than 1.0 are rejected, unless XML11
+// is set.
+//
+// The fields of a Tokenizer must be set before its first call to RawToken, and
+// apply however the Tokenizer is used: by a Canonicalizer, by Parse, or by a
+// RawTokenReader that wraps it.
+type Tokenizer struct {
+	// CharsetReader, if non-nil, is used as it is by xml.Decoder: to obtain a
+	// reader of UTF-8 from a document that declares another encoding. If nil,
+	// such documents are rejected.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// EntityResolver obtains the content of the external parsed entities the
+	// document references, once each. If it is nil, a reference to one is an
+	// error.
+	EntityResolver EntityResolver
+
+	// MaxEntityExpansion is the maximum total size, in bytes, of the
+	// replacement text produced by expanding entity references. Zero means
+	// DefaultMaxEntityExpansion. Documents that exceed it are rejected with a
+	// *LimitError.
+	MaxEntityExpansion int
+
+	// XML11 reads documents that declare version 1.1 as that version
+	// requires: NEL and LS characters are line endings, and character
+	// references may denote control characters. A Canonicalizer reading such
+	// documents must have XML11 set too.
+	XML11 bool
+
+	t       *xmltok.Tokenizer
+	resolve func(publicID, systemID string) ([]byte, error) // calls EntityResolver
+}
+
+// NewTokenizer returns a Tokenizer that reads a document from r.
+func NewTokenizer(r io.Reader) *Tokenizer {
+	t := &Tokenizer{t: xmltok.New(r)}
+	t.resolve = func(publicID, systemID string) ([]byte, error) {
+		return t.EntityResolver.ResolveEntity(publicID, systemID)
+	}
+
+	return t
+}
+
+// TokenInfo describes how a token returned by a Tokenizer was written, in ways
+// that the token itself does not record.
+type TokenInfo struct {
+	// Line and Column are the position in the document at which the token
+	// starts. A token within the replacement text of an entity has the
+	// position of the end of the outermost reference to it.
+	Line, Column int
+
+	// Entity is the name of the innermost general entity whose replacement
+	// text holds the token, or empty if the token is in the document itself.
+	Entity string
+
+	// Reference is, for a CharData token that came from a character reference
+	// or a reference to a predefined entity, the reference as written without
+	// its leading '&' and trailing ';', such as "#x20" or "amp".
+	Reference string
+
+	// CDATA is true for a CharData token that came from a CDATA section.
+	CDATA bool
+
+	// Quotes is, for a StartElement token, the quotation mark each attribute
+	// value was written with: '"' or '\''. It must not be modified.
+	Quotes []byte
+}
+
+// Info describes the token most recently returned by RawToken.
+func (t *Tokenizer) Info() TokenInfo {
+	return TokenInfo(t.t.Info())
+}
+
+// RawToken implements RawTokenReader.
+func (t *Tokenizer) RawToken() (xml.Token, error) {
+	t.t.CharsetReader = t.CharsetReader
+	t.t.MaxEntityExpansion = t.MaxEntityExpansion
+	t.t.XML11 = t.XML11
+	t.t.Resolve = nil
+	if t.EntityResolver != nil {
+		t.t.Resolve = t.resolve
+	}
+
+	tok, err := t.t.RawToken()
+	if err == xmltok.ErrLimit {
+		max := t.MaxEntityExpansion
+		if max <= 0 {
+			max = DefaultMaxEntityExpansion
+		}
+
+		return nil, &LimitError{Limit: "MaxEntityExpansion", Max: max}
+	}
+
+	return tok, err
+}
@@ -0,0 +1,128 @@
+package c14n_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleNewTokenizer() {
+	input := `<!DOCTYPE doc [<!ENTITY sig "<b>Bob</b>">]><doc z="2" a="1">Thanks, &sig;</doc>`
+	out, err := c14n.Canonicalize(c14n.NewTokenizer(strings.NewReader(input)))
+	fmt.Println(string(out), err)
+	// Output:
+	// <doc a="1" z="2">Thanks, <b>Bob</b></doc> <nil>
+}
+
+func TestNewTokenizer(t *testing.T) {
+	for _, tt := range readTestCases(t) {
+		t.Run(tt.Name, func(t *testing.T) {
+			if tt.Options.Skip != "" {
+				t.Skip(tt.Options.Skip)
+			}
+
+			if tt.Options.DecoderOnly != "" {
+				t.Skip(tt.Options.DecoderOnly)
+			}
+
+			c := tt.Options.canonicalizer(t, tt.Dir)
+			actual, err := c.Canonicalize(tt.Options.tokenizer(tt.In, tt.Dir))
+
+			// The tokenizer reports malformed input itself, so the error
+			// messages may differ.
+			if tt.Options.Error != "" {
+				assert.Error(t, err)
+				return
+			}
+
+			tt.check(t, actual, err)
+		})
+	}
+}
+
+func TestNewTokenizer_EntityLimit(t *testing.T) {
+	input := `<!DOCTYPE doc [<!ENTITY a "aaaaaaaaaa"><!ENTITY b "&a;&a;&a;&a;&a;&a;&a;&a;&a;&a;">]><doc>&b;</doc>`
+
+	// Entities are expanded, and limited, even without ProcessDTD.
+	tokenizer := c14n.NewTokenizer(strings.NewReader(input))
+	tokenizer.MaxEntityExpansion = 100
+	_, err := c14n.Canonicalize(tokenizer)
+	assert.Equal(t, &c14n.LimitError{Limit: "MaxEntityExpansion", Max: 100}, err)
+
+	tokenizer = c14n.NewTokenizer(strings.NewReader(input))
+	tokenizer.MaxEntityExpansion = 130
+	out, err := c14n.Canonicalize(tokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, "<doc>"+strings.Repeat("a", 100)+"</doc>", string(out))
+}
+
+func TestNewTokenizer_EntityResolver(t *testing.T) {
+	input := `<!DOCTYPE doc [<!ENTITY ent SYSTEM "ent.xml">]><doc>&ent;</doc>`
+
+	tokenizer := c14n.NewTokenizer(strings.NewReader(input))
+	tokenizer.EntityResolver = c14n.MapResolver{"ent.xml": `<b a="1"/>`}
+	out, err := c14n.Canonicalize(tokenizer)
+	assert.NoError(t, err)
+	assert.Equal(t, `<doc><b a="1"></b></doc>`, string(out))
+
+	// Without a resolver, the reference can't be expanded.
+	_, err = c14n.Canonicalize(c14n.NewTokenizer(strings.NewReader(input)))
+	assert.EqualError(t, err, "XML syntax error on line 1: column 58: cannot resolve external entity &ent;")
+}
+
+func TestTokenizer_Info(t *testing.T) {
+	input := `<!DOCTYPE doc [<!ENTITY e "<b>&amp;</b>">]>` + "\n" +
+		`<doc a="1" b='2'>x&#x20;<![CDATA[y]]>&e;</doc>`
+	tokenizer := c14n.NewTokenizer(strings.NewReader(input))
+
+	var infos []c14n.TokenInfo
+	for {
+		_, err := tokenizer.RawToken()
+		if err == io.EOF {
+			break
+		}
+
+		assert.NoError(t, err)
+		infos = append(infos, tokenizer.Info())
+	}
+
+	assert.Equal(t, []c14n.TokenInfo{
+		{Line: 1, Column: 1},
+		{Line: 1, Column: 44},
+		{Line: 2, Column: 1, Quotes: []byte(`"'`)},
+		{Line: 2, Column: 18},
+		{Line: 2, Column: 19, Reference: "#x20"},
+		{Line: 2, Column: 25, CDATA: true},
+		{Line: 2, Column: 41, Entity: "e"},
+		{Line: 2, Column: 41, Entity: "e", Reference: "amp"},
+		{Line: 2, Column: 41, Entity: "e"},
+		{Line: 2, Column: 41},
+	}, infos)
+}
+
+func TestNewTokenizer_Wrapped(t *testing.T) {
+	input := `<?xml version="1.1"?><!DOCTYPE doc [<!ENTITY ent SYSTEM "ent.xml">]><doc>&#x1;&ent;</doc>`
+
+	// The Tokenizer's settings apply however it is read: here, by Parse,
+	// through a reader that wraps it.
+	tokenizer := c14n.NewTokenizer(strings.NewReader(input))
+	tokenizer.XML11 = true
+	tokenizer.EntityResolver = c14n.MapResolver{"ent.xml": "world"}
+	el, err := c14n.Parse(wrappedReader{tokenizer})
+	assert.NoError(t, err)
+	assert.Equal(t, []c14n.Node{c14n.Text("\x01world")}, el.Children)
+}
+
+// wrappedReader hides the type of the RawTokenReader it wraps.
+type wrappedReader struct {
+	r c14n.RawTokenReader
+}
+
+func (w wrappedReader) RawToken() (xml.Token, error) {
+	return w.r.RawToken()
+}
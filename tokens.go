@@ -0,0 +1,38 @@
+package c14n
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+)
+
+// TokenSlice is a RawTokenReader that returns the tokens in the slice, in
+// order. Each call to RawToken removes the first token from the slice. Once the
+// slice is empty, RawToken returns io.EOF.
+//
+// As with any RawTokenReader, names in the tokens should hold prefixes, not
+// namespace URIs.
+type TokenSlice []xml.Token
+
+// RawToken implements RawTokenReader.
+func (s *TokenSlice) RawToken() (xml.Token, error) {
+	if len(*s) == 0 {
+		return nil, io.EOF
+	}
+
+	t := (*s)[0]
+	*s = (*s)[1:]
+	return t, nil
+}
+
+// CanonicalizeValue returns the canonicalized representation of the XML
+// encoding of v. It is shorthand for calling Canonicalize on the output of
+// xml.Marshal.
+func CanonicalizeValue(v interface{}) ([]byte, error) {
+	b, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return Canonicalize(xml.NewDecoder(bytes.NewReader(b)))
+}
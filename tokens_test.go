@@ -0,0 +1,149 @@
+package c14n_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func ExampleTokenSlice() {
+	tokens := c14n.TokenSlice{
+		xml.StartElement{
+			Name: xml.Name{Local: "foo"},
+			Attr: []xml.Attr{
+				xml.Attr{Name: xml.Name{Local: "z"}, Value: "2"},
+				xml.Attr{Name: xml.Name{Local: "a"}, Value: "1"},
+			},
+		},
+		xml.CharData("bar"),
+		xml.EndElement{Name: xml.Name{Local: "foo"}},
+	}
+
+	out, err := c14n.Canonicalize(&tokens)
+	fmt.Println(string(out), err)
+	// Output:
+	// <foo a="1" z="2">bar</foo> <nil>
+}
+
+func ExampleCanonicalizeValue() {
+	type Issuer struct {
+		XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+		Format  string   `xml:"Format,attr"`
+		Value   string   `xml:",chardata"`
+	}
+
+	out, err := c14n.CanonicalizeValue(Issuer{
+		Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+		Value:  "http://idp.example.com",
+	})
+
+	fmt.Println(string(out), err)
+	// Output:
+	// <Issuer xmlns="urn:oasis:names:tc:SAML:2.0:assertion" Format="urn:oasis:names:tc:SAML:2.0:nameid-format:entity">http://idp.example.com</Issuer> <nil>
+}
+
+func TestTokenSlice(t *testing.T) {
+	type testCase struct {
+		In  c14n.TokenSlice
+		Out string
+		Err error
+	}
+
+	testCases := []testCase{
+		testCase{
+			In:  c14n.TokenSlice{},
+			Err: io.ErrUnexpectedEOF,
+		},
+		testCase{
+			In: c14n.TokenSlice{
+				xml.StartElement{Name: xml.Name{Local: "foo"}},
+			},
+			Err: io.ErrUnexpectedEOF,
+		},
+		testCase{
+			In: c14n.TokenSlice{
+				xml.Comment("skipped"),
+				xml.StartElement{Name: xml.Name{Local: "foo"}},
+				xml.EndElement{Name: xml.Name{Local: "foo"}},
+				xml.Comment("skipped"),
+			},
+			Out: `<foo></foo>`,
+		},
+		testCase{
+			In: c14n.TokenSlice{
+				xml.StartElement{
+					Name: xml.Name{Space: "a", Local: "foo"},
+					Attr: []xml.Attr{
+						xml.Attr{Name: xml.Name{Space: "xmlns", Local: "a"}, Value: "http://example.com"},
+						xml.Attr{Name: xml.Name{Space: "xmlns", Local: "b"}, Value: "http://example.com"},
+					},
+				},
+				xml.StartElement{Name: xml.Name{Space: "b", Local: "bar"}},
+				xml.CharData("<&>\r"),
+				xml.EndElement{Name: xml.Name{Space: "b", Local: "bar"}},
+				xml.EndElement{Name: xml.Name{Space: "a", Local: "foo"}},
+			},
+			Out: `<a:foo xmlns:a="http://example.com"><b:bar xmlns:b="http://example.com">&lt;&amp;&gt;&#xD;</b:bar></a:foo>`,
+		},
+		testCase{
+			In: c14n.TokenSlice{
+				xml.StartElement{
+					Name: xml.Name{Local: "foo"},
+					Attr: []xml.Attr{
+						xml.Attr{Name: xml.Name{Local: "bar"}, Value: "<&\"\t\n\r>"},
+					},
+				},
+				xml.EndElement{Name: xml.Name{Local: "foo"}},
+			},
+			Out: `<foo bar="&lt;&amp;&quot;&#x9;&#xA;&#xD;>"></foo>`,
+		},
+	}
+
+	for i, tt := range testCases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			out, err := c14n.Canonicalize(&tt.In)
+			assert.Equal(t, tt.Err, err)
+			assert.Equal(t, tt.Out, string(out))
+		})
+	}
+}
+
+func TestCanonicalizeValue(t *testing.T) {
+	type Audience struct {
+		Value string `xml:",chardata"`
+	}
+
+	type AudienceRestriction struct {
+		XMLName   xml.Name   `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+		Audiences []Audience `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+	}
+
+	type Conditions struct {
+		XMLName             xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+		NotOnOrAfter        string   `xml:"NotOnOrAfter,attr"`
+		NotBefore           string   `xml:"NotBefore,attr"`
+		AudienceRestriction AudienceRestriction
+	}
+
+	out, err := c14n.CanonicalizeValue(Conditions{
+		NotOnOrAfter: "2020-05-26T00:27:42Z",
+		NotBefore:    "2020-05-26T00:21:42Z",
+		AudienceRestriction: AudienceRestriction{
+			Audiences: []Audience{
+				Audience{Value: "http://sp.example.com"},
+			},
+		},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, `<Conditions xmlns="urn:oasis:names:tc:SAML:2.0:assertion" NotBefore="2020-05-26T00:21:42Z" NotOnOrAfter="2020-05-26T00:27:42Z"><AudienceRestriction><Audience>http://sp.example.com</Audience></AudienceRestriction></Conditions>`, string(out))
+}
+
+func TestCanonicalizeValue_MarshalError(t *testing.T) {
+	_, err := c14n.CanonicalizeValue(make(chan int))
+	assert.Error(t, err)
+}
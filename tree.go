@@ -0,0 +1,209 @@
+package c14n
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// Node is a node in an XML element tree. It is implemented by *Element, Text,
+// Comment, and PI.
+type Node interface {
+	node()
+}
+
+// Element is an XML element.
+//
+// As with RawTokenReader, names are not namespace-resolved: Name.Space holds
+// the prefix the element was written with, not a namespace URI. Namespace
+// declarations are held in Attr, as xmlns or xmlns:prefix attributes.
+type Element struct {
+	Name     xml.Name
+	Attr     []Attr
+	Children []Node
+}
+
+// Attr is an attribute of an XML element. Name.Space holds the attribute's
+// prefix, not its namespace URI.
+type Attr struct {
+	Name  xml.Name
+	Value string
+}
+
+// Text is a run of character data, with any entity and character references
+// already resolved.
+type Text string
+
+// Comment is an XML comment. It does not include the <!-- and --> markers.
+type Comment string
+
+// PI is an XML processing instruction.
+type PI struct {
+	Target string
+	Inst   string
+}
+
+func (*Element) node() {}
+func (Text) node()     {}
+func (Comment) node()  {}
+func (PI) node()       {}
+
+// Parse reads the first root-level element from a sequence of raw XML tokens,
+// and returns it as an element tree. As with Canonicalize, any leading
+// character data, comments, or directives are skipped.
+//
+// Directives within the root element are discarded. Adjacent character data
+// tokens are merged into a single Text node. Parse returns ErrUnbalanced if
+// an EndElement does not match its StartElement, or precedes any
+// StartElement.
+func Parse(r RawTokenReader) (*Element, error) {
+	var stack []*Element // the currently open elements; the root comes first
+	for {
+		t, err := r.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+
+		// Don't start building the tree until we've reached a StartElement. An
+		// EndElement before then has nothing to match.
+		switch t.(type) {
+		case xml.StartElement:
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, ErrUnbalanced
+			}
+		default:
+			if len(stack) == 0 {
+				continue
+			}
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			el := &Element{Name: t.Name, Attr: make([]Attr, len(t.Attr))}
+			for i, attr := range t.Attr {
+				el.Attr[i] = Attr(attr)
+			}
+
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, el)
+			}
+
+			stack = append(stack, el)
+		case xml.EndElement:
+			el := stack[len(stack)-1]
+			if el.Name != t.Name {
+				return nil, ErrUnbalanced
+			}
+
+			stack = stack[:len(stack)-1]
+
+			if len(stack) == 0 {
+				return el, nil
+			}
+		case xml.CharData:
+			parent := stack[len(stack)-1]
+			if n := len(parent.Children); n > 0 {
+				if text, ok := parent.Children[n-1].(Text); ok {
+					parent.Children[n-1] = text + Text(t)
+					continue
+				}
+			}
+
+			parent.Children = append(parent.Children, Text(t))
+		case xml.Comment:
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, Comment(t))
+		case xml.ProcInst:
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, PI{Target: t.Target, Inst: string(t.Inst)})
+		}
+	}
+}
+
+// CanonicalizeElement returns the canonicalized representation of an element
+// tree. Its output is identical to that of calling Canonicalize on the tokens
+// the tree was parsed from.
+//
+// Comments are omitted from the output, as they are by Canonicalize. A tree
+// holding characters that XML does not allow produces an *InvalidCharError.
+func CanonicalizeElement(el *Element) ([]byte, error) {
+	c := getCanonicalizer()
+	defer putCanonicalizer(c)
+
+	c.e.reset(nil)
+	if err := c.e.element(el); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, c.e.buf.Len())
+	copy(out, c.e.buf.Bytes())
+	return out, nil
+}
+
+// element renders an element and all of its descendants.
+func (e *encoder) element(el *Element) error {
+	start := xml.StartElement{Name: el.Name, Attr: make([]xml.Attr, len(el.Attr))}
+	for i, attr := range el.Attr {
+		start.Attr[i] = xml.Attr(attr)
+	}
+
+	if err := e.treeToken(start); err != nil {
+		return err
+	}
+
+	for _, child := range el.Children {
+		var err error
+		switch child := child.(type) {
+		case *Element:
+			err = e.element(child)
+		case Text:
+			err = e.treeToken(xml.CharData(child))
+		case Comment:
+			err = e.treeToken(xml.Comment(child))
+		case PI:
+			err = e.treeToken(xml.ProcInst{Target: child.Target, Inst: []byte(child.Inst)})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.treeToken(xml.EndElement{Name: el.Name})
+}
+
+// treeToken renders a single token of an element tree. Tokens are counted, and
+// checked for malformed markup and invalid characters, as if they had been read
+// in order.
+func (e *encoder) treeToken(t xml.Token) error {
+	e.tokens++
+
+	if err := e.checkMarkup(t); err != nil {
+		return err
+	}
+
+	t, err := e.checkChars(t)
+	if err != nil {
+		return err
+	}
+
+	switch t := t.(type) {
+	case xml.StartElement:
+		e.startElement(t)
+	case xml.EndElement:
+		e.endElement(t)
+	case xml.CharData:
+		e.charData(t)
+	case xml.Comment:
+		e.comment(t)
+	case xml.ProcInst:
+		e.procInst(t)
+	}
+
+	return nil
+}
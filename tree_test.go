@@ -0,0 +1,104 @@
+package c14n_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+	"golang.org/x/net/html/charset"
+)
+
+func ExampleCanonicalizeElement() {
+	el := &c14n.Element{
+		Name: xml.Name{Local: "foo"},
+		Attr: []c14n.Attr{
+			c14n.Attr{Name: xml.Name{Local: "z"}, Value: "2"},
+			c14n.Attr{Name: xml.Name{Local: "a"}, Value: "1"},
+		},
+		Children: []c14n.Node{
+			&c14n.Element{Name: xml.Name{Local: "bar"}},
+			c14n.Comment(" ignored "),
+			c14n.Text("a < b"),
+		},
+	}
+
+	out, err := c14n.CanonicalizeElement(el)
+	fmt.Println(string(out), err)
+	// Output:
+	// <foo a="1" z="2"><bar></bar>a &lt; b</foo> <nil>
+}
+
+func TestCanonicalizeElement(t *testing.T) {
+	for _, tt := range readTestCases(t) {
+		t.Run(tt.Name, func(t *testing.T) {
+			// CanonicalizeElement only supports the default options.
+			if !tt.Options.isDefault() {
+				t.Skip("test case uses options")
+			}
+
+			decoder := xml.NewDecoder(bytes.NewReader(tt.In))
+			decoder.CharsetReader = charset.NewReaderLabel
+
+			el, err := c14n.Parse(decoder)
+			assert.NoError(t, err)
+
+			actual, err := c14n.CanonicalizeElement(el)
+			assert.NoError(t, err)
+			assert.Equal(t, string(tt.Out), string(actual))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	input := `<?xml version="1.0"?><!-- skipped --><a:foo xmlns:a="http://example.com" bar="baz">x<![CDATA[y]]>z<!--c--><?pi inst?><qux /></a:foo><!-- skipped -->`
+	decoder := xml.NewDecoder(strings.NewReader(input))
+
+	el, err := c14n.Parse(decoder)
+	assert.NoError(t, err)
+	assert.Equal(t, &c14n.Element{
+		Name: xml.Name{Space: "a", Local: "foo"},
+		Attr: []c14n.Attr{
+			c14n.Attr{Name: xml.Name{Space: "xmlns", Local: "a"}, Value: "http://example.com"},
+			c14n.Attr{Name: xml.Name{Local: "bar"}, Value: "baz"},
+		},
+		Children: []c14n.Node{
+			c14n.Text("xyz"),
+			c14n.Comment("c"),
+			c14n.PI{Target: "pi", Inst: "inst"},
+			&c14n.Element{Name: xml.Name{Local: "qux"}, Attr: []c14n.Attr{}},
+		},
+	}, el)
+}
+
+func TestParse_NoStartElement(t *testing.T) {
+	decoder := xml.NewDecoder(strings.NewReader("<!-- foo -->"))
+	_, err := c14n.Parse(decoder)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestParse_Unbalanced(t *testing.T) {
+	inputs := []string{
+		`</foo>`,
+		`</foo><foo></foo>`,
+		`<foo></bar>`,
+		`<foo><bar></foo>`,
+	}
+
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			decoder := xml.NewDecoder(strings.NewReader(input))
+			_, err := c14n.Parse(decoder)
+			assert.Equal(t, c14n.ErrUnbalanced, err)
+		})
+	}
+}
+
+func TestParse_RawTokenError(t *testing.T) {
+	_, err := c14n.Parse(&errRawTokener{})
+	assert.Equal(t, errDummy, err)
+}
@@ -0,0 +1,40 @@
+package c14n
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+// pushSpace records whether whitespace is to be preserved within t, an element
+// just opened: that is, whether it or its nearest ancestor with an xml:space
+// attribute has xml:space="preserve". Other values of xml:space are treated
+// like "default".
+func (e *encoder) pushSpace(t xml.StartElement) {
+	preserve := false
+	if n := len(e.preserveSpace); n > 0 {
+		preserve = e.preserveSpace[n-1]
+	}
+
+	for _, attr := range t.Attr {
+		if attr.Name.Space == "xml" && attr.Name.Local == "space" {
+			preserve = attr.Value == "preserve"
+		}
+	}
+
+	e.preserveSpace = append(e.preserveSpace, preserve)
+}
+
+// popSpace forgets the most recent element recorded by pushSpace.
+func (e *encoder) popSpace() {
+	e.preserveSpace = e.preserveSpace[:len(e.preserveSpace)-1]
+}
+
+// trimText returns text with its leading and trailing whitespace removed,
+// unless whitespace is to be preserved within the innermost open element.
+func (e *encoder) trimText(text []byte) []byte {
+	if n := len(e.preserveSpace); n > 0 && e.preserveSpace[n-1] {
+		return text
+	}
+
+	return bytes.Trim(text, " \t\r\n")
+}
@@ -0,0 +1,141 @@
+package c14n
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/ucarion/c14n/internal/xmlchar"
+)
+
+// ErrXML11 is returned when the input declares itself an XML 1.1 document, and
+// the Canonicalizer does not have XML11 set.
+var ErrXML11 = errors.New("c14n: XML 1.1 documents are not supported unless XML11 is set")
+
+// checkVersion checks the version in t, an XML declaration, and notes whether
+// the input is an XML 1.1 document.
+func (e *encoder) checkVersion(t xml.ProcInst) error {
+	if declaredVersion(t.Inst) != "1.1" {
+		return nil
+	}
+
+	if !e.xml11 {
+		return ErrXML11
+	}
+
+	e.version11 = true
+	return nil
+}
+
+// declaredVersion returns the value of the version pseudo-attribute of an XML
+// declaration, given the declaration's contents, or "" if it has none.
+func declaredVersion(inst []byte) string {
+	i := bytes.Index(inst, []byte("version"))
+	if i < 0 {
+		return ""
+	}
+
+	s := bytes.TrimLeft(inst[i+len("version"):], " \t\r\n")
+	if len(s) == 0 || s[0] != '=' {
+		return ""
+	}
+
+	s = bytes.TrimLeft(s[1:], " \t\r\n")
+	if len(s) == 0 || s[0] != '"' && s[0] != '\'' {
+		return ""
+	}
+
+	end := bytes.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return ""
+	}
+
+	return string(s[1 : end+1])
+}
+
+// isLiteralChar11 returns whether r may be written literally in an XML 1.1
+// document: whether it matches the Char production of XML 1.1, but not the
+// RestrictedChar production. Restricted characters may only be written as
+// character references, which comments and processing instructions cannot
+// hold.
+//
+// https://www.w3.org/TR/xml11/#NT-RestrictedChar
+func isLiteralChar11(r rune) bool {
+	return xmlchar.IsChar11(r) && !xmlchar.IsRestrictedChar(r)
+}
+
+// mustReference11 returns whether r must be rendered as a character reference
+// in the text and attribute values of an XML 1.1 document: whether it is a
+// restricted character, or a line ending that XML 1.1 adds, which a parser
+// would otherwise normalize to #xA.
+func mustReference11(r rune) bool {
+	return xmlchar.IsRestrictedChar(r) || r == 0x85 || r == 0x2028
+}
+
+// escapeText11 is like escapeText, but also renders the characters for which
+// mustReference11 is true as hexadecimal character references, in the style of
+// "&#xD;".
+func escapeText11(buf *bytes.Buffer, s []byte) {
+	last := 0 // the start of the run of bytes not yet written
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		if !mustReference11(r) {
+			i += size
+			continue
+		}
+
+		escapeText(buf, s[last:i])
+		fmt.Fprintf(buf, "&#x%X;", r)
+		i += size
+		last = i
+	}
+
+	escapeText(buf, s[last:])
+}
+
+// escapeAttr11 is like escapeAttr, but also renders the characters for which
+// mustReference11 is true as character references, as escapeText11 does.
+func escapeAttr11(buf *bytes.Buffer, s string) {
+	last := 0 // the start of the run of bytes not yet written
+	for i, r := range s {
+		if !mustReference11(r) {
+			continue
+		}
+
+		escapeAttr(buf, s[last:i])
+		fmt.Fprintf(buf, "&#x%X;", r)
+		last = i + utf8.RuneLen(r)
+	}
+
+	escapeAttr(buf, s[last:])
+}
+
+// normalizeLineEndings11 applies the end-of-line handling of XML 1.1 to s in
+// place: each CRLF or CR NEL sequence, and each CR, NEL or LS not part of one,
+// is replaced by a single LF. It returns the shortened slice.
+//
+// https://www.w3.org/TR/xml11/#sec-line-ends
+func normalizeLineEndings11(s []byte) []byte {
+	out := s[:0]
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRune(s[i:])
+		switch r {
+		case '\r':
+			if next, n := utf8.DecodeRune(s[i+size:]); next == '\n' || next == 0x85 {
+				size += n
+			}
+		case 0x85, 0x2028:
+		default:
+			out = append(out, s[i:i+size]...)
+			i += size
+			continue
+		}
+
+		out = append(out, '\n')
+		i += size
+	}
+
+	return out
+}
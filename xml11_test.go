@@ -0,0 +1,54 @@
+package c14n_test
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ucarion/c14n"
+)
+
+func TestCanonicalize_XML11(t *testing.T) {
+	doc := xml.Name{Local: "doc"}
+	tokens := func(decl string, rest ...xml.Token) *c14n.TokenSlice {
+		s := c14n.TokenSlice{
+			xml.ProcInst{Target: "xml", Inst: []byte(decl)},
+			xml.StartElement{Name: doc, Attr: []xml.Attr{{Name: xml.Name{Local: "a"}, Value: "\x01\u0085\t"}}},
+		}
+
+		s = append(s, rest...)
+		s = append(s, xml.EndElement{Name: doc})
+		return &s
+	}
+
+	// XML 1.0 documents are unaffected by XML11.
+	c := c14n.Canonicalizer{XML11: true}
+	_, err := c.Canonicalize(tokens(`version="1.0"`))
+	assert.EqualError(t, err, `c14n: invalid character U+0001 at offset 0 of attribute "a" of token 2`)
+
+	// XML 1.1 documents are rejected without XML11, however the version is
+	// quoted.
+	for _, decl := range []string{`version="1.1"`, `version = '1.1' encoding="UTF-8"`} {
+		_, err = c14n.Canonicalize(tokens(decl))
+		assert.Equal(t, c14n.ErrXML11, err)
+	}
+
+	out, err := c.Canonicalize(tokens(`version="1.1"`, xml.CharData("\x7f\u0085\u2028\r\n"), xml.Comment("\u0085")))
+	assert.NoError(t, err)
+	assert.Equal(t, "<doc a=\"&#x1;&#x85;&#x9;\">&#x7F;&#x85;&#x2028;&#xD;\n</doc>", string(out))
+
+	// Restricted characters cannot be written as references in comments.
+	c.WithComments = true
+	_, err = c.Canonicalize(tokens(`version="1.1"`, xml.Comment("\x01")))
+	assert.EqualError(t, err, "c14n: invalid character U+0001 at offset 0 of token 3")
+
+	// NUL is not allowed in XML 1.1 either.
+	_, err = c.Canonicalize(tokens(`version="1.1"`, xml.CharData("\x00")))
+	assert.EqualError(t, err, "c14n: invalid character U+0000 at offset 0 of token 3")
+
+	c.WithComments = false
+	c.NormalizeLineEndings = true
+	out, err = c.Canonicalize(tokens(`version="1.1"`, xml.CharData("a\r\u0085b\u0085c\u2028d\r"), xml.CharData("\ne")))
+	assert.NoError(t, err)
+	assert.Equal(t, "<doc a=\"&#x1;&#xA;&#x9;\">a\nb\nc\nd\ne</doc>", string(out))
+}